@@ -2,9 +2,12 @@ package apo
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/deitas/apo/envelope"
 )
@@ -44,20 +47,20 @@ func ParseJSON(input []byte, options ...envelope.Options) (*envelope.Envelope, e
 	return _envelope, err
 }
 
-func Read(reader io.Reader) (*envelope.Envelope, error) {
+func Read(reader io.Reader, options ...envelope.DecodeOptions) (*envelope.Envelope, error) {
 	var (
 		err       error
 		_envelope *envelope.Envelope = envelope.NewEnvelope()
 	)
 
-	if err = _envelope.Decode(reader); err != nil {
+	if err = _envelope.Decode(reader, options...); err != nil {
 		return nil, err
 	}
 
 	return _envelope, err
 }
 
-func ReadFile(name string) (*envelope.Envelope, error) {
+func ReadFile(name string, options ...envelope.DecodeOptions) (*envelope.Envelope, error) {
 	var (
 		err       error
 		file      *os.File
@@ -67,10 +70,215 @@ func ReadFile(name string) (*envelope.Envelope, error) {
 	if file, err = os.Open(name); err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	if err = _envelope.Decode(file); err != nil {
+	if err = _envelope.Decode(file, options...); err != nil {
 		return nil, err
 	}
 
 	return _envelope, err
 }
+
+// Open returns a lazily decoded envelope reading blocks from the file on demand.
+// The envelope must be closed to release the file.
+func Open(name string, options ...envelope.DecodeOptions) (*envelope.Reader, error) {
+	var (
+		err      error
+		file     *os.File
+		fileStat os.FileInfo
+		reader   *envelope.Reader
+	)
+
+	if file, err = os.Open(name); err != nil {
+		return nil, err
+	}
+
+	if fileStat, err = file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if reader, err = envelope.NewReaderAt(file, fileStat.Size(), options...); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return reader, err
+}
+
+// OpenMapped is like Open, but memory maps the file where supported, so string and
+// binary blocks refer to the mapped memory without copying and must not be used after Close.
+func OpenMapped(name string, options ...envelope.DecodeOptions) (*envelope.Reader, error) {
+	var (
+		err        error
+		file       *os.File
+		fileStat   os.FileInfo
+		mappedFile *mappedFile
+		reader     *envelope.Reader
+	)
+
+	if file, err = os.Open(name); err != nil {
+		return nil, err
+	}
+
+	if fileStat, err = file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if mappedFile, err = mapFile(file, fileStat.Size()); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if reader, err = envelope.NewReaderAt(mappedFile, fileStat.Size(), options...); err != nil {
+		mappedFile.Close()
+		return nil, err
+	}
+
+	return reader, err
+}
+
+func NewReaderAt(readerAt io.ReaderAt, size int64, options ...envelope.DecodeOptions) (*envelope.Reader, error) {
+	return envelope.NewReaderAt(readerAt, size, options...)
+}
+
+// OpenFile opens the envelope stored in the file with flag as os.OpenFile does. Blocks
+// are decoded on demand, with os.O_RDWR they can be updated in place or appended in
+// journal segments. The envelope must be closed to release the file.
+func OpenFile(name string, flag int, options ...envelope.DecodeOptions) (*envelope.File, error) {
+	var (
+		err          error
+		file         *os.File
+		fileStat     os.FileInfo
+		envelopeFile *envelope.File
+	)
+
+	if file, err = os.OpenFile(name, flag, 0); err != nil {
+		return nil, err
+	}
+
+	if fileStat, err = file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if envelopeFile, err = envelope.NewFile(file, fileStat.Size(), options...); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return envelopeFile, err
+}
+
+// CompactJournal rewrites the file with a single index, dropping the journal segments
+// and the blocks replaced by them. The file is replaced atomically, see WriteFile.
+func CompactJournal(name string) error {
+	var (
+		err      error
+		reader   *envelope.Reader
+		fileStat os.FileInfo
+	)
+
+	if reader, err = Open(name); err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if fileStat, err = os.Stat(name); err != nil {
+		return err
+	}
+
+	// blocks are loaded upfront, as encoding fails on allocated blocks which are not loaded
+	for _, address := range reader.Index.AllocatedAddresses {
+		if _, err = reader.Block(address); err != nil {
+			return err
+		}
+	}
+
+	// the mode of the file is kept as is, rather than masked by the umask again
+	return writeFile(name, reader.Envelope, fileStat.Mode().Perm(), true)
+}
+
+type WriteOptions struct {
+	// Lock serializes concurrent writers with an advisory lock on the file
+	// name with a ".lock" suffix, where supported (Linux).
+	Lock bool
+}
+
+// WriteFile encodes the envelope into a temporary file in the directory of name, syncs it
+// and renames it to name, so readers never see a partially written file. The file is created
+// with perm masked by the umask, as os.OpenFile does, replacing the mode of an existing file.
+func WriteFile(name string, _envelope *envelope.Envelope, perm os.FileMode, options ...WriteOptions) error {
+	return writeFile(name, _envelope, perm, false, options...)
+}
+
+func writeFile(name string, _envelope *envelope.Envelope, perm os.FileMode, isExactPerm bool, options ...WriteOptions) error {
+	var (
+		err          error
+		writeOptions WriteOptions
+		unlock       func() error
+		tempFile     *os.File
+	)
+
+	if len(options) > 0 {
+		writeOptions = options[0]
+	}
+
+	if writeOptions.Lock {
+		if unlock, err = lockFile(name+".lock", perm); err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	if tempFile, err = createTempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp-", perm); err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if err = _envelope.EncodeStream(tempFile); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if isExactPerm {
+		if err = tempFile.Chmod(perm); err != nil {
+			tempFile.Close()
+			return err
+		}
+	}
+
+	if err = tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tempFile.Name(), name); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(name))
+}
+
+// createTempFile creates a new file in dir with a random name following prefix, like
+// ioutil.TempFile, but with perm masked by the umask instead of mode 0600.
+func createTempFile(dir string, prefix string, perm os.FileMode) (file *os.File, err error) {
+	var suffix []byte = make([]byte, 8)
+
+	for try := 0; try < 100; try++ {
+		if _, err = rand.Read(suffix); err != nil {
+			return
+		}
+
+		if file, err = os.OpenFile(filepath.Join(dir, prefix+hex.EncodeToString(suffix)), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm); !os.IsExist(err) {
+			return
+		}
+	}
+
+	return
+}
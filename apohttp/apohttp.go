@@ -0,0 +1,100 @@
+// Package apohttp serves and posts envelopes over HTTP.
+package apohttp
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/deitas/apo/envelope"
+)
+
+const (
+	MediaType     = "application/vnd.apo"
+	JSONMediaType = "application/json"
+)
+
+func init() {
+	mime.AddExtensionType(".apo", MediaType)
+}
+
+type Options struct {
+	// MaxBodySize bounds request bodies read by Handler and response bodies
+	// read by Post, zero disables the limit.
+	MaxBodySize   int64
+	DecodeOptions envelope.DecodeOptions
+}
+
+func (options Options) decodeOptions() envelope.DecodeOptions {
+	var decodeOptions envelope.DecodeOptions = options.DecodeOptions
+
+	if options.MaxBodySize > 0 && (decodeOptions.MaxSize == 0 || decodeOptions.MaxSize > options.MaxBodySize) {
+		decodeOptions.MaxSize = options.MaxBodySize
+	}
+
+	return decodeOptions
+}
+
+// Negotiate returns the media type of the response preferred by the Accept header, MediaType
+// or JSONMediaType. MediaType is preferred on ties and without the header, an empty string is
+// returned when neither is acceptable.
+func Negotiate(request *http.Request) string {
+	var (
+		accept       string = request.Header.Get("Accept")
+		apoQuality   float64
+		jsonQuality  float64
+		wildQuality  float64 = -1
+		hasAPO       bool
+		hasJSON      bool
+		mediaQuality float64
+	)
+
+	if strings.TrimSpace(accept) == "" {
+		return MediaType
+	}
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		mediaQuality = 1
+
+		if quality, hasQuality := params["q"]; hasQuality {
+			if mediaQuality, err = strconv.ParseFloat(quality, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case MediaType:
+			apoQuality, hasAPO = mediaQuality, true
+		case JSONMediaType:
+			jsonQuality, hasJSON = mediaQuality, true
+		case "*/*", "application/*":
+			if mediaQuality > wildQuality {
+				wildQuality = mediaQuality
+			}
+		}
+	}
+
+	if !hasAPO && wildQuality >= 0 {
+		apoQuality = wildQuality
+	}
+
+	if !hasJSON && wildQuality >= 0 {
+		jsonQuality = wildQuality
+	}
+
+	if apoQuality <= 0 && jsonQuality <= 0 {
+		return ""
+	}
+
+	if apoQuality >= jsonQuality {
+		return MediaType
+	}
+
+	return JSONMediaType
+}
@@ -0,0 +1,43 @@
+package apohttp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", MediaType},
+		{"   ", MediaType},
+		{MediaType, MediaType},
+		{JSONMediaType, JSONMediaType},
+		{"application/json, application/vnd.apo", MediaType},
+		{"application/json;q=0.9, application/vnd.apo;q=0.8", JSONMediaType},
+		{"application/vnd.apo;q=0.5, application/json;q=0.5", MediaType},
+		{"*/*", MediaType},
+		{"application/*", MediaType},
+		{"*/*;q=0.1, application/json", JSONMediaType},
+		{"application/vnd.apo;q=0, */*", JSONMediaType},
+		{"application/json;q=0, application/vnd.apo;q=0", ""},
+		{"text/html", ""},
+		{"text/html, application/json;q=invalid", ""},
+		{"invalid;;, application/json", JSONMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			var request = httptest.NewRequest("GET", "/", nil)
+
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+
+			if actual := Negotiate(request); actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package apohttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/envelope"
+)
+
+// StatusError is returned by Post when the server replies with a status other than 2xx.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (statusError *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", statusError.StatusCode, http.StatusText(statusError.StatusCode), statusError.Message)
+}
+
+// Post sends the envelope to url with http.DefaultClient and decodes the envelope
+// of the response, nil when the response has no body.
+func Post(url string, request *envelope.Envelope, options ...Options) (response *envelope.Envelope, err error) {
+	return PostWithClient(http.DefaultClient, url, request, options...)
+}
+
+func PostWithClient(client *http.Client, url string, request *envelope.Envelope, options ...Options) (response *envelope.Envelope, err error) {
+	var (
+		postOptions  Options
+		body         []byte
+		httpRequest  *http.Request
+		httpResponse *http.Response
+		message      []byte
+	)
+
+	if len(options) > 0 {
+		postOptions = options[0]
+	}
+
+	if body, err = request.Marshal(); err != nil {
+		return
+	}
+
+	if httpRequest, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body)); err != nil {
+		return
+	}
+
+	httpRequest.Header.Set("Content-Type", MediaType)
+	httpRequest.Header.Set("Accept", MediaType)
+
+	if httpResponse, err = client.Do(httpRequest); err != nil {
+		return
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode > 299 {
+		message, _ = ioutil.ReadAll(io.LimitReader(httpResponse.Body, 4096))
+		err = &StatusError{StatusCode: httpResponse.StatusCode, Message: string(bytes.TrimSpace(message))}
+		return
+	}
+
+	if httpResponse.StatusCode == http.StatusNoContent {
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(httpResponse.Header.Get("Content-Type")); mediaType != MediaType {
+		err = fmt.Errorf("unexpected response content type %q", httpResponse.Header.Get("Content-Type"))
+		return
+	}
+
+	return apo.Read(httpResponse.Body, postOptions.decodeOptions())
+}
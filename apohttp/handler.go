@@ -0,0 +1,108 @@
+package apohttp
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/envelope"
+)
+
+// HandlerFunc handles the envelope decoded from the request body, nil for requests without
+// a body. A nil response envelope replies with 204 No Content, an error with 500.
+type HandlerFunc func(request *envelope.Envelope, httpRequest *http.Request) (response *envelope.Envelope, err error)
+
+type handler struct {
+	handler HandlerFunc
+	options Options
+}
+
+// Handler adapts handlerFunc to http.Handler. Request bodies must be of MediaType,
+// responses are written as negotiated by Negotiate.
+func Handler(handlerFunc HandlerFunc, options ...Options) http.Handler {
+	var adapter *handler = &handler{handler: handlerFunc}
+
+	if len(options) > 0 {
+		adapter.options = options[0]
+	}
+
+	return adapter
+}
+
+func (adapter *handler) ServeHTTP(writer http.ResponseWriter, httpRequest *http.Request) {
+	var (
+		request  *envelope.Envelope
+		response *envelope.Envelope
+		err      error
+	)
+
+	if Negotiate(httpRequest) == "" {
+		http.Error(writer, "response is available as "+MediaType+" or "+JSONMediaType, http.StatusNotAcceptable)
+		return
+	}
+
+	if httpRequest.Body != nil && httpRequest.Body != http.NoBody && httpRequest.ContentLength != 0 {
+		if mediaType, _, _ := mime.ParseMediaType(httpRequest.Header.Get("Content-Type")); mediaType != MediaType {
+			http.Error(writer, "request body must be "+MediaType, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if adapter.options.MaxBodySize > 0 {
+			httpRequest.Body = http.MaxBytesReader(writer, httpRequest.Body, adapter.options.MaxBodySize)
+		}
+
+		if request, err = apo.Read(httpRequest.Body, adapter.options.decodeOptions()); errors.Is(err, block.ErrLimitExceeded) {
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if response, err = adapter.handler(request, httpRequest); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if response == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err = WriteEnvelope(writer, httpRequest, http.StatusOK, response); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WriteEnvelope writes the envelope with status, encoded as negotiated by Negotiate.
+// Nothing is written when encoding fails, so the caller can still reply with an error.
+func WriteEnvelope(writer http.ResponseWriter, httpRequest *http.Request, status int, response *envelope.Envelope) (err error) {
+	var (
+		mediaType string = Negotiate(httpRequest)
+		buffer    bytes.Buffer
+	)
+
+	if mediaType == JSONMediaType {
+		err = response.EncodeJSON(&buffer)
+	} else {
+		mediaType = MediaType
+		err = response.Encode(&buffer)
+	}
+
+	if err != nil {
+		return
+	}
+
+	writer.Header().Set("Content-Type", mediaType)
+	writer.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	writer.Header().Add("Vary", "Accept")
+	writer.WriteHeader(status)
+
+	_, err = buffer.WriteTo(writer)
+	return
+}
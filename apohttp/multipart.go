@@ -0,0 +1,327 @@
+package apohttp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/envelope"
+)
+
+// Forms are converted into a root object keyed by the names of the parts. Values are
+// string blocks, files are objects holding the file name, the content type and a binary
+// block with the data. Parts sharing a name are collected into an array.
+const (
+	FilenameKey    = "filename"
+	ContentTypeKey = "contentType"
+	DataKey        = "data"
+)
+
+type MultipartOptions struct {
+	// SpillDir is the directory of temporary files holding file data, os.TempDir() is used when empty.
+	SpillDir string
+	// MaxValueSize bounds values held in memory, MaxFileSize the data of every file and
+	// MaxParts the number of parts read, zero values disable a limit.
+	MaxValueSize int64
+	MaxFileSize  int64
+	MaxParts     int
+}
+
+type multipartField struct {
+	name   string
+	blocks []block.Block
+}
+
+// Form is an envelope read by ReadMultipart, its binary blocks read file data from
+// temporary files. The form must be closed to release them.
+type Form struct {
+	*envelope.Envelope
+	files []*os.File
+}
+
+// Close closes the temporary files holding the file data of the form.
+func (form *Form) Close() (err error) {
+	for _, file := range form.files {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	form.files = nil
+	return
+}
+
+// ReadMultipart converts the parts of reader into an envelope. File data is streamed into
+// temporary files which are removed right away, so they are released once the form is
+// closed on systems which allow removing open files.
+//
+// Files are objects holding FilenameKey, ContentTypeKey and DataKey rather than bare binary
+// blocks, since the name and the MIME type of a binary block are not encoded. Exceeding
+// a limit of options fails with block.ErrLimitExceeded.
+func ReadMultipart(reader *multipart.Reader, options ...MultipartOptions) (form *Form, err error) {
+	var (
+		multipartOptions MultipartOptions
+		fields           []*multipartField
+		fieldsByName     map[string]*multipartField = map[string]*multipartField{}
+		part             *multipart.Part
+		partBlock        block.Block
+		parts            int
+	)
+
+	if len(options) > 0 {
+		multipartOptions = options[0]
+	}
+
+	form = &Form{
+		Envelope: envelope.NewEnvelope(),
+	}
+
+	defer func() {
+		if err != nil {
+			form.Close()
+			form = nil
+		}
+	}()
+
+	for {
+		if part, err = reader.NextPart(); err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+
+		if parts++; multipartOptions.MaxParts > 0 && parts > multipartOptions.MaxParts {
+			part.Close()
+			err = fmt.Errorf("%w: form has more than %d parts", block.ErrLimitExceeded, multipartOptions.MaxParts)
+			return
+		}
+
+		if part.FormName() == "" {
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			partBlock, err = readMultipartValue(form.Envelope, part, multipartOptions)
+		} else {
+			partBlock, err = form.readFile(part, multipartOptions)
+		}
+
+		part.Close()
+
+		if err != nil {
+			return
+		}
+
+		field, hasField := fieldsByName[part.FormName()]
+		if !hasField {
+			field = &multipartField{name: part.FormName()}
+			fieldsByName[field.name] = field
+			fields = append(fields, field)
+		}
+
+		field.blocks = append(field.blocks, partBlock)
+	}
+
+	_, err = form.Builder().Object(func(object *envelope.ObjectBuilder) {
+		for _, field := range fields {
+			if len(field.blocks) == 1 {
+				object.Block(field.name, field.blocks[0])
+				continue
+			}
+
+			object.Array(field.name, func(array *envelope.ArrayBuilder) {
+				for _, fieldBlock := range field.blocks {
+					array.Block(fieldBlock)
+				}
+			})
+		}
+	})
+
+	return
+}
+
+func readMultipartValue(_envelope *envelope.Envelope, part *multipart.Part, options MultipartOptions) (valueBlock block.Block, err error) {
+	var (
+		reader io.Reader = part
+		value  []byte
+	)
+
+	if options.MaxValueSize > 0 {
+		reader = io.LimitReader(part, options.MaxValueSize+1)
+	}
+
+	if value, err = ioutil.ReadAll(reader); err != nil {
+		return
+	}
+
+	if options.MaxValueSize > 0 && int64(len(value)) > options.MaxValueSize {
+		err = fmt.Errorf("%w: value of %q is larger than %d bytes", block.ErrLimitExceeded, part.FormName(), options.MaxValueSize)
+		return
+	}
+
+	return _envelope.AddString(string(value))
+}
+
+func (form *Form) readFile(part *multipart.Part, options MultipartOptions) (fileBlock block.Block, err error) {
+	var (
+		spillFile   *os.File
+		reader      io.Reader = part
+		size        int64
+		contentType string = part.Header.Get("Content-Type")
+		binaryBlock *envelope.BinaryBlock
+	)
+
+	if options.MaxFileSize > 0 {
+		reader = io.LimitReader(part, options.MaxFileSize+1)
+	}
+
+	if spillFile, err = ioutil.TempFile(options.SpillDir, "apo-multipart-"); err != nil {
+		return
+	}
+
+	// the open file stays readable until the form is closed
+	os.Remove(spillFile.Name())
+	form.files = append(form.files, spillFile)
+
+	if size, err = io.Copy(spillFile, reader); err != nil {
+		return
+	}
+
+	if options.MaxFileSize > 0 && size > options.MaxFileSize {
+		err = fmt.Errorf("%w: file of %q is larger than %d bytes", block.ErrLimitExceeded, part.FormName(), options.MaxFileSize)
+		return
+	}
+
+	if binaryBlock, err = form.AddBinaryReaderAt(spillFile, size); err != nil {
+		return
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	binaryBlock.Name = part.FileName()
+	binaryBlock.MIME = contentType
+
+	fileBlock, err = form.Builder().Object(func(object *envelope.ObjectBuilder) {
+		object.String(FilenameKey, binaryBlock.Name).String(ContentTypeKey, binaryBlock.MIME).Block(DataKey, binaryBlock)
+	})
+
+	return
+}
+
+// WriteMultipart writes the root object of the envelope as parts of writer, the reverse of
+// ReadMultipart. Binary blocks are written as files, other scalar blocks as values.
+// The writer is not closed.
+func WriteMultipart(writer *multipart.Writer, _envelope *envelope.Envelope) (err error) {
+	root, isObject := _envelope.Root().(*envelope.ObjectBlock)
+
+	if !isObject || root.IsArray() {
+		return fmt.Errorf("multipart form must be an object")
+	}
+
+	for _, child := range root.Children() {
+		var name string = fmt.Sprint(child.Key)
+
+		if array, isArray := child.Block.(*envelope.ObjectBlock); isArray && array.IsArray() {
+			for _, item := range array.Children() {
+				if err = writeMultipartPart(writer, name, item.Block); err != nil {
+					return
+				}
+			}
+
+			continue
+		}
+
+		if err = writeMultipartPart(writer, name, child.Block); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func writeMultipartPart(writer *multipart.Writer, name string, partBlock block.Block) (err error) {
+	var (
+		value      interface{}
+		partWriter io.Writer
+	)
+
+	switch typedBlock := partBlock.(type) {
+	case *envelope.StringBlock:
+		return writer.WriteField(name, typedBlock.String())
+	case *envelope.BinaryBlock:
+		return writeMultipartFile(writer, name, typedBlock.Name, typedBlock.MIME, typedBlock)
+	case *envelope.ObjectBlock:
+		dataBlock, hasData := typedBlock.Lookup(DataKey)
+		binaryBlock, isBinary := dataBlock.(*envelope.BinaryBlock)
+
+		if !hasData || !isBinary {
+			return fmt.Errorf("part %q is an object without %q binary data", name, DataKey)
+		}
+
+		filename, _ := lookupString(typedBlock, FilenameKey)
+		contentType, _ := lookupString(typedBlock, ContentTypeKey)
+
+		return writeMultipartFile(writer, name, filename, contentType, binaryBlock)
+	default:
+		if value, err = partBlock.Interface(); err != nil {
+			return
+		}
+
+		if partWriter, err = writer.CreateFormField(name); err != nil {
+			return
+		}
+
+		if value != nil {
+			_, err = fmt.Fprint(partWriter, value)
+		}
+
+		return
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func writeMultipartFile(writer *multipart.Writer, name string, filename string, contentType string, binaryBlock *envelope.BinaryBlock) (err error) {
+	var (
+		header     textproto.MIMEHeader = textproto.MIMEHeader{}
+		partWriter io.Writer
+	)
+
+	if filename == "" {
+		filename = name
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(name), quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", contentType)
+
+	if partWriter, err = writer.CreatePart(header); err != nil {
+		return
+	}
+
+	_, err = io.Copy(partWriter, binaryBlock.Reader())
+	return
+}
+
+// lookupString returns the value of the string block under key, an empty string when there is none.
+func lookupString(objectBlock *envelope.ObjectBlock, key string) (value string, hasValue bool) {
+	child, hasChild := objectBlock.Lookup(key)
+	stringBlock, isString := child.(*envelope.StringBlock)
+
+	if !hasChild || !isString {
+		return
+	}
+
+	return stringBlock.String(), true
+}
@@ -0,0 +1,75 @@
+package apohttp
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/deitas/apo/block"
+)
+
+func TestReadMultipart(t *testing.T) {
+	var (
+		body     bytes.Buffer
+		writer   *multipart.Writer = multipart.NewWriter(&body)
+		expected string            = `{"a":["10","20"],"f":{"filename":"x.txt","contentType":"application/octet-stream","data":"MDEyMzQ1Njc4OQ=="}}`
+	)
+
+	writer.WriteField("a", "10")
+	writer.WriteField("a", "20")
+
+	part, err := writer.CreateFormFile("f", "x.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	part.Write([]byte("0123456789"))
+	writer.Close()
+
+	tests := []struct {
+		name    string
+		options MultipartOptions
+		isLimit bool
+	}{
+		{"no limits", MultipartOptions{}, false},
+		{"max value size", MultipartOptions{MaxValueSize: 2}, false},
+		{"max value size exceeded", MultipartOptions{MaxValueSize: 1}, true},
+		{"max file size", MultipartOptions{MaxFileSize: 10}, false},
+		{"max file size exceeded", MultipartOptions{MaxFileSize: 9}, true},
+		{"max parts", MultipartOptions{MaxParts: 3}, false},
+		{"max parts exceeded", MultipartOptions{MaxParts: 2}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.SpillDir = t.TempDir()
+
+			form, err := ReadMultipart(multipart.NewReader(bytes.NewReader(body.Bytes()), writer.Boundary()), test.options)
+
+			if test.isLimit {
+				if !errors.Is(err, block.ErrLimitExceeded) {
+					t.Fatalf("expected %v, got %v", block.ErrLimitExceeded, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer form.Close()
+
+			var buffer bytes.Buffer
+
+			if err = form.EncodeJSON(&buffer); err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := strings.TrimSpace(buffer.String()); actual != expected {
+				t.Fatalf("expected %s, got %s", expected, actual)
+			}
+		})
+	}
+}
@@ -20,6 +20,31 @@ const (
 	DateTime
 )
 
+func (blockType BlockType) String() string {
+	switch blockType {
+	case Address:
+		return "Address"
+	case Empty:
+		return "Empty"
+	case Object:
+		return "Object"
+	case Binary:
+		return "Binary"
+	case Boolean:
+		return "Boolean"
+	case String:
+		return "String"
+	case Int:
+		return "Int"
+	case Float:
+		return "Float"
+	case DateTime:
+		return "DateTime"
+	default:
+		return fmt.Sprintf("BlockType(%d)", int(blockType))
+	}
+}
+
 func (blockType BlockType) Bitmask() byte {
 	return byte(blockType) << 4
 }
@@ -75,6 +100,26 @@ func (blockAddress BlockAddress) ToBytes(addressBytes int) (address []byte, err
 	return
 }
 
+// DecodeBlockAddress reads a little endian address of addressBytes length from the start of data.
+func DecodeBlockAddress(data []byte, addressBytes int) (address BlockAddress, err error) {
+	var buffer []byte = make([]byte, 8)
+
+	if addressBytes < 1 || addressBytes > 8 {
+		err = fmt.Errorf("%w: address must be 1 to 8 bytes, got %d", ErrInvalidSize, addressBytes)
+		return
+	}
+
+	if len(data) < addressBytes {
+		err = ErrTruncated
+		return
+	}
+
+	copy(buffer, data[:addressBytes])
+	address = BlockAddress(binary.LittleEndian.Uint64(buffer))
+
+	return
+}
+
 type Block interface {
 	Type() BlockType
 
@@ -84,6 +129,10 @@ type Block interface {
 	Key() interface{}
 	SetKey(interface{}) error
 
+	Parents() []Block
+
+	Interface() (interface{}, error)
+
 	IsRequest() bool
 	SetIsRequest(bool) Block
 
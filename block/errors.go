@@ -0,0 +1,78 @@
+package block
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNotAPO             = errors.New("not APO file")
+	ErrTruncated          = errors.New("unexpected end of data")
+	ErrInvalidSize        = errors.New("invalid size")
+	ErrInvalidType        = errors.New("invalid block type")
+	ErrInvalidKey         = errors.New("invalid key")
+	ErrUnknownAddress     = errors.New("unknown block address")
+	ErrLimitExceeded      = errors.New("limit exceeded")
+	ErrUnsupportedVersion = errors.New("unsupported format version")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+)
+
+type Section int
+
+const (
+	SectionHeader Section = iota
+	SectionIndex
+	SectionBlocks
+)
+
+func (section Section) String() string {
+	switch section {
+	case SectionHeader:
+		return "header"
+	case SectionIndex:
+		return "index"
+	case SectionBlocks:
+		return "blocks"
+	default:
+		return fmt.Sprintf("section(%d)", int(section))
+	}
+}
+
+// DecodeError describes where decoding of APO data failed. Address is zero
+// when the failure is not related to a particular block, in which case
+// BlockType and Key are not set either.
+type DecodeError struct {
+	Offset    int64
+	Section   Section
+	Address   BlockAddress
+	BlockType BlockType
+	Key       interface{}
+	Err       error
+}
+
+func (decodeError *DecodeError) Error() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "decode %s at offset %d", decodeError.Section, decodeError.Offset)
+
+	if decodeError.Address != 0 {
+		fmt.Fprintf(&builder, " (block %d, %s", decodeError.Address, decodeError.BlockType)
+
+		if decodeError.Key != nil {
+			fmt.Fprintf(&builder, ", key %#v", decodeError.Key)
+		}
+
+		builder.WriteString(")")
+	}
+
+	if decodeError.Err != nil {
+		fmt.Fprintf(&builder, ": %s", decodeError.Err)
+	}
+
+	return builder.String()
+}
+
+func (decodeError *DecodeError) Unwrap() error {
+	return decodeError.Err
+}
@@ -0,0 +1,228 @@
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/deitas/apo/envelope"
+)
+
+// Broker serves a Local bus to clients, it can also be used in-process like Local.
+type Broker struct {
+	*Local
+	options   Options
+	mutex     sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+}
+
+// brokerConn tracks subscriptions made by a single client.
+type brokerConn struct {
+	broker        *Broker
+	peer          *peer
+	mutex         sync.Mutex
+	subscriptions map[int64]Subscription
+}
+
+func NewBroker(options ...Options) *Broker {
+	var broker *Broker = &Broker{
+		Local:     NewLocal(),
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+	}
+
+	if len(options) > 0 {
+		broker.options = options[0]
+	}
+
+	return broker
+}
+
+// ListenAndServe serves clients on network ("unix" or "tcp") and address.
+func (broker *Broker) ListenAndServe(network string, address string) (err error) {
+	var listener net.Listener
+
+	if listener, err = net.Listen(network, address); err != nil {
+		return
+	}
+
+	return broker.Serve(listener)
+}
+
+// Serve accepts connections of listener until it fails or the broker is closed.
+func (broker *Broker) Serve(listener net.Listener) (err error) {
+	var conn net.Conn
+
+	broker.mutex.Lock()
+	broker.listeners[listener] = true
+	broker.mutex.Unlock()
+
+	defer func() {
+		broker.mutex.Lock()
+		delete(broker.listeners, listener)
+		broker.mutex.Unlock()
+
+		listener.Close()
+	}()
+
+	for {
+		if conn, err = listener.Accept(); err != nil {
+			if broker.isClosed() {
+				err = ErrClosed
+			}
+
+			return
+		}
+
+		go broker.ServeConn(conn)
+	}
+}
+
+// ServeConn handles requests of a single client until the connection ends,
+// its subscriptions are removed afterwards.
+func (broker *Broker) ServeConn(conn net.Conn) (err error) {
+	var current *brokerConn = &brokerConn{
+		broker:        broker,
+		subscriptions: map[int64]Subscription{},
+	}
+
+	broker.mutex.Lock()
+	broker.conns[conn] = true
+	broker.mutex.Unlock()
+
+	current.peer = newPeer(conn, broker.options, current.handle)
+	err = current.peer.run()
+
+	broker.mutex.Lock()
+	delete(broker.conns, conn)
+	broker.mutex.Unlock()
+
+	current.mutex.Lock()
+	defer current.mutex.Unlock()
+
+	for id, subscription := range current.subscriptions {
+		subscription.Unsubscribe()
+		delete(current.subscriptions, id)
+	}
+
+	return
+}
+
+// Close closes listeners and connections served by the broker and removes all subscriptions.
+func (broker *Broker) Close() (err error) {
+	broker.Local.Close()
+
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	for listener := range broker.listeners {
+		listener.Close()
+	}
+
+	for conn := range broker.conns {
+		conn.Close()
+	}
+
+	return
+}
+
+func (broker *Broker) isClosed() bool {
+	broker.Local.mutex.RLock()
+	defer broker.Local.mutex.RUnlock()
+
+	return broker.Local.isClosed
+}
+
+func (current *brokerConn) handle(method string, args *envelope.ObjectBlock) (result interface{}, err error) {
+	var (
+		topic        string
+		id           int64
+		message      *envelope.Envelope
+		subscription Subscription
+	)
+
+	switch method {
+	case methodPublish:
+		if topic, err = lookupString(args, topicKey); err != nil {
+			return
+		}
+
+		if message, err = lookupMessage(args, current.broker.options); err != nil {
+			return
+		}
+
+		if err = current.broker.Publish(topic, message); err != nil {
+			return
+		}
+	case methodSubscribe:
+		if topic, err = lookupString(args, topicKey); err != nil {
+			return
+		}
+
+		if id, err = lookupInt(args, subscriptionKey); err != nil {
+			return
+		}
+
+		current.mutex.Lock()
+		defer current.mutex.Unlock()
+
+		if _, isSubscribed := current.subscriptions[id]; isSubscribed {
+			err = fmt.Errorf("subscription %d already exists", id)
+			return
+		}
+
+		if subscription, err = current.broker.Subscribe(topic, current.deliver(id)); err != nil {
+			return
+		}
+
+		current.subscriptions[id] = subscription
+	case methodUnsubscribe:
+		if id, err = lookupInt(args, subscriptionKey); err != nil {
+			return
+		}
+
+		current.mutex.Lock()
+		defer current.mutex.Unlock()
+
+		if subscription = current.subscriptions[id]; subscription == nil {
+			err = fmt.Errorf("unknown subscription %d", id)
+			return
+		}
+
+		delete(current.subscriptions, id)
+
+		if err = subscription.Unsubscribe(); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("unknown method %q", method)
+		return
+	}
+
+	return true, nil
+}
+
+// deliver forwards messages to the client and waits for its acknowledgement.
+func (current *brokerConn) deliver(id int64) Handler {
+	return func(topic string, message *envelope.Envelope) (err error) {
+		var data []byte
+
+		if data, err = message.Marshal(); err != nil {
+			return
+		}
+
+		_, err = current.peer.call(methodDeliver, map[string]interface{}{
+			subscriptionKey: id,
+			topicKey:        topic,
+			messageKey:      data,
+		})
+
+		if errors.Is(err, ErrClosed) {
+			err = fmt.Errorf("subscriber disconnected: %w", err)
+		}
+
+		return
+	}
+}
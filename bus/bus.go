@@ -0,0 +1,55 @@
+// Package bus delivers envelopes published on a topic to every subscriber of the topic.
+//
+// Local is an in-process implementation, Broker serves it to clients connected over Unix
+// or TCP sockets with Client. Clients exchange framed envelopes with the broker: publish,
+// subscribe and unsubscribe are requests as added by envelope.AddRequest, messages are
+// delivered to clients as requests too and acknowledged with response blocks.
+package bus
+
+import (
+	"errors"
+	"time"
+
+	"github.com/deitas/apo/envelope"
+)
+
+const (
+	methodPublish     = "publish"
+	methodSubscribe   = "subscribe"
+	methodUnsubscribe = "unsubscribe"
+	methodDeliver     = "deliver"
+
+	topicKey        = "topic"
+	messageKey      = "message"
+	subscriptionKey = "subscription"
+)
+
+var (
+	ErrClosed  = errors.New("bus is closed")
+	ErrTimeout = errors.New("timed out waiting for a response")
+)
+
+// Handler receives messages of a subscription, returning an error rejects the message.
+// Every handler receives its own copy of a published message.
+type Handler func(topic string, message *envelope.Envelope) error
+
+type Subscription interface {
+	Unsubscribe() error
+}
+
+type Bus interface {
+	// Publish delivers the message to current subscribers of topic and waits for
+	// all of them to acknowledge it, returning the first rejection.
+	Publish(topic string, message *envelope.Envelope) error
+	Subscribe(topic string, handler Handler) (Subscription, error)
+	Close() error
+}
+
+type Options struct {
+	// MaxFrameSize bounds received envelopes, zero disables the limit.
+	MaxFrameSize uint32
+	// Timeout bounds the wait for a response of the other side, so a stuck subscriber
+	// rejects deliveries instead of blocking publishers. Zero waits until the connection ends.
+	Timeout       time.Duration
+	DecodeOptions envelope.DecodeOptions
+}
@@ -0,0 +1,155 @@
+package bus
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/deitas/apo/envelope"
+)
+
+// Client is a bus connected to a Broker. Handlers of its subscriptions run
+// concurrently, the broker waits for them before acknowledging a publish.
+type Client struct {
+	peer          *peer
+	options       Options
+	mutex         sync.Mutex
+	lastID        int64
+	subscriptions map[int64]*clientSubscription
+}
+
+type clientSubscription struct {
+	client  *Client
+	id      int64
+	topic   string
+	handler Handler
+}
+
+func NewClient(conn net.Conn, options ...Options) *Client {
+	var client *Client = &Client{
+		subscriptions: map[int64]*clientSubscription{},
+	}
+
+	if len(options) > 0 {
+		client.options = options[0]
+	}
+
+	client.peer = newPeer(conn, client.options, client.handle)
+
+	go client.peer.run()
+
+	return client
+}
+
+// Dial connects to a broker on network ("unix" or "tcp") and address.
+func Dial(network string, address string, options ...Options) (client *Client, err error) {
+	var conn net.Conn
+
+	if conn, err = net.Dial(network, address); err != nil {
+		return
+	}
+
+	return NewClient(conn, options...), nil
+}
+
+func (client *Client) Publish(topic string, message *envelope.Envelope) (err error) {
+	var data []byte
+
+	if data, err = message.Marshal(); err != nil {
+		return
+	}
+
+	_, err = client.peer.call(methodPublish, map[string]interface{}{
+		topicKey:   topic,
+		messageKey: data,
+	})
+
+	return
+}
+
+func (client *Client) Subscribe(topic string, handler Handler) (_ Subscription, err error) {
+	var subscription *clientSubscription = &clientSubscription{
+		client:  client,
+		topic:   topic,
+		handler: handler,
+	}
+
+	// the handler is registered first, so no delivery misses it
+	client.mutex.Lock()
+	client.lastID++
+	subscription.id = client.lastID
+	client.subscriptions[subscription.id] = subscription
+	client.mutex.Unlock()
+
+	if _, err = client.peer.call(methodSubscribe, map[string]interface{}{
+		topicKey:        topic,
+		subscriptionKey: subscription.id,
+	}); err != nil {
+		client.removeSubscription(subscription.id)
+		return
+	}
+
+	return subscription, nil
+}
+
+// Close closes the connection, the broker removes subscriptions of the client.
+func (client *Client) Close() error {
+	return client.peer.conn.Close()
+}
+
+func (client *Client) removeSubscription(id int64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	delete(client.subscriptions, id)
+}
+
+func (client *Client) handle(method string, args *envelope.ObjectBlock) (result interface{}, err error) {
+	var (
+		id      int64
+		topic   string
+		message *envelope.Envelope
+	)
+
+	if method != methodDeliver {
+		err = fmt.Errorf("unknown method %q", method)
+		return
+	}
+
+	if id, err = lookupInt(args, subscriptionKey); err != nil {
+		return
+	}
+
+	if topic, err = lookupString(args, topicKey); err != nil {
+		return
+	}
+
+	client.mutex.Lock()
+	subscription := client.subscriptions[id]
+	client.mutex.Unlock()
+
+	if subscription == nil {
+		err = fmt.Errorf("unknown subscription %d", id)
+		return
+	}
+
+	if message, err = lookupMessage(args, client.options); err != nil {
+		return
+	}
+
+	if err = subscription.handler(topic, message); err != nil {
+		return
+	}
+
+	return true, nil
+}
+
+func (subscription *clientSubscription) Unsubscribe() (err error) {
+	subscription.client.removeSubscription(subscription.id)
+
+	_, err = subscription.client.peer.call(methodUnsubscribe, map[string]interface{}{
+		subscriptionKey: subscription.id,
+	})
+
+	return
+}
@@ -0,0 +1,136 @@
+package bus
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/deitas/apo/envelope"
+)
+
+// Local is a bus delivering messages within the process, handlers run concurrently.
+type Local struct {
+	mutex         sync.RWMutex
+	subscriptions map[string]map[*localSubscription]bool
+	isClosed      bool
+}
+
+type localSubscription struct {
+	local   *Local
+	topic   string
+	handler Handler
+}
+
+func NewLocal() *Local {
+	return &Local{
+		subscriptions: map[string]map[*localSubscription]bool{},
+	}
+}
+
+func (local *Local) Publish(topic string, message *envelope.Envelope) (err error) {
+	var (
+		handlers  []Handler
+		data      []byte
+		waitGroup sync.WaitGroup
+		errs      []error
+		errsMutex sync.Mutex
+	)
+
+	local.mutex.RLock()
+
+	if local.isClosed {
+		local.mutex.RUnlock()
+		return ErrClosed
+	}
+
+	for subscription := range local.subscriptions[topic] {
+		handlers = append(handlers, subscription.handler)
+	}
+
+	local.mutex.RUnlock()
+
+	if len(handlers) == 0 {
+		return
+	}
+
+	// handlers receive copies decoded from a single encoding, since encoding updates the envelope
+	if data, err = message.Marshal(); err != nil {
+		return
+	}
+
+	for _, handler := range handlers {
+		waitGroup.Add(1)
+
+		go func(handler Handler) {
+			defer waitGroup.Done()
+
+			copied := envelope.NewEnvelope()
+			handlerErr := copied.Decode(bytes.NewReader(data))
+
+			if handlerErr == nil {
+				handlerErr = handler(topic, copied)
+			}
+
+			if handlerErr != nil {
+				errsMutex.Lock()
+				errs = append(errs, handlerErr)
+				errsMutex.Unlock()
+			}
+		}(handler)
+	}
+
+	waitGroup.Wait()
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("%d of %d subscribers rejected the message: %w", len(errs), len(handlers), errs[0])
+	}
+
+	return
+}
+
+func (local *Local) Subscribe(topic string, handler Handler) (Subscription, error) {
+	var subscription *localSubscription = &localSubscription{
+		local:   local,
+		topic:   topic,
+		handler: handler,
+	}
+
+	local.mutex.Lock()
+	defer local.mutex.Unlock()
+
+	if local.isClosed {
+		return nil, ErrClosed
+	}
+
+	if local.subscriptions[topic] == nil {
+		local.subscriptions[topic] = map[*localSubscription]bool{}
+	}
+
+	local.subscriptions[topic][subscription] = true
+
+	return subscription, nil
+}
+
+// Close removes all subscriptions, publishing and subscribing fails afterwards.
+func (local *Local) Close() error {
+	local.mutex.Lock()
+	defer local.mutex.Unlock()
+
+	local.isClosed = true
+	local.subscriptions = map[string]map[*localSubscription]bool{}
+
+	return nil
+}
+
+func (subscription *localSubscription) Unsubscribe() error {
+	subscription.local.mutex.Lock()
+	defer subscription.local.mutex.Unlock()
+
+	delete(subscription.local.subscriptions[subscription.topic], subscription)
+
+	if len(subscription.local.subscriptions[subscription.topic]) == 0 {
+		delete(subscription.local.subscriptions, subscription.topic)
+	}
+
+	return nil
+}
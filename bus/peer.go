@@ -0,0 +1,239 @@
+package bus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/envelope"
+)
+
+// peer exchanges requests and responses in both directions over a connection.
+type peer struct {
+	conn          net.Conn
+	frameReader   *apo.FrameReader
+	frameWriter   *apo.FrameWriter
+	handleRequest func(method string, args *envelope.ObjectBlock) (result interface{}, err error)
+	timeout       time.Duration
+	mutex         sync.Mutex
+	lastID        int64
+	pending       map[int64]chan *envelope.ObjectBlock
+	done          chan struct{}
+	err           error
+}
+
+func newPeer(conn net.Conn, options Options, handleRequest func(string, *envelope.ObjectBlock) (interface{}, error)) *peer {
+	return &peer{
+		conn:          conn,
+		frameReader:   apo.NewFrameReader(conn, options.MaxFrameSize, options.DecodeOptions),
+		frameWriter:   apo.NewFrameWriter(conn),
+		handleRequest: handleRequest,
+		timeout:       options.Timeout,
+		pending:       map[int64]chan *envelope.ObjectBlock{},
+		done:          make(chan struct{}),
+	}
+}
+
+// run reads envelopes until the connection fails, requests are handled in their own goroutines.
+func (peer *peer) run() (err error) {
+	var message *envelope.Envelope
+
+	defer func() {
+		peer.mutex.Lock()
+		peer.err = err
+		peer.mutex.Unlock()
+
+		close(peer.done)
+		peer.conn.Close()
+	}()
+
+	for {
+		if message, err = peer.frameReader.ReadEnvelope(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return
+		}
+
+		for _, request := range message.Requests() {
+			go peer.reply(request)
+		}
+
+		for _, response := range message.Responses() {
+			id, _ := envelope.RequestID(response)
+			responseID, _ := id.(int64)
+
+			peer.mutex.Lock()
+			pending, isPending := peer.pending[responseID]
+			delete(peer.pending, responseID)
+			peer.mutex.Unlock()
+
+			if isPending {
+				pending <- response
+			}
+		}
+	}
+}
+
+func (peer *peer) reply(request *envelope.ObjectBlock) {
+	var (
+		response  *envelope.Envelope = envelope.NewEnvelope()
+		method    string
+		args      *envelope.ObjectBlock
+		result    interface{}
+		handleErr error
+	)
+
+	if method, handleErr = lookupString(request, envelope.RequestMethodKey); handleErr == nil {
+		if args, handleErr = lookupObject(request, envelope.RequestArgsKey); handleErr == nil {
+			result, handleErr = peer.handleRequest(method, args)
+		}
+	}
+
+	// a result which cannot be added is reported to the caller instead, so it does not wait for it
+	if _, err := response.AddResponse(request, result, handleErr); err != nil {
+		if _, err = response.AddResponse(request, nil, err); err != nil {
+			return
+		}
+	}
+
+	// a failed write ends the connection, which is reported by run
+	peer.frameWriter.WriteEnvelope(response)
+}
+
+// call sends a request and waits for its response, at most for the timeout of the peer.
+func (peer *peer) call(method string, args interface{}) (result interface{}, err error) {
+	var (
+		request  *envelope.Envelope         = envelope.NewEnvelope()
+		pending  chan *envelope.ObjectBlock = make(chan *envelope.ObjectBlock, 1)
+		response *envelope.ObjectBlock
+		timeout  <-chan time.Time
+		id       int64
+		message  string
+	)
+
+	peer.mutex.Lock()
+	peer.lastID++
+	id = peer.lastID
+	peer.pending[id] = pending
+	peer.mutex.Unlock()
+
+	defer func() {
+		peer.mutex.Lock()
+		delete(peer.pending, id)
+		peer.mutex.Unlock()
+	}()
+
+	if _, err = request.AddRequest(id, method, args); err != nil {
+		return
+	}
+
+	if err = peer.frameWriter.WriteEnvelope(request); err != nil {
+		return
+	}
+
+	if peer.timeout > 0 {
+		timer := time.NewTimer(peer.timeout)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case response = <-pending:
+	case <-peer.done:
+		err = peer.closedErr()
+		return
+	case <-timeout:
+		err = fmt.Errorf("%w: no response to %q within %s", ErrTimeout, method, peer.timeout)
+		return
+	}
+
+	if message, err = lookupString(response, envelope.ResponseErrorKey); err == nil {
+		err = errors.New(message)
+		return
+	}
+
+	err = nil
+
+	if child, hasChild := response.Lookup(envelope.ResponseResultKey); hasChild {
+		result, err = child.Interface()
+	}
+
+	return
+}
+
+func (peer *peer) closedErr() error {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if peer.err != nil {
+		return fmt.Errorf("%w: %s", ErrClosed, peer.err)
+	}
+
+	return ErrClosed
+}
+
+func lookupString(objectBlock *envelope.ObjectBlock, key string) (value string, err error) {
+	child, hasChild := objectBlock.Lookup(key)
+	stringBlock, isString := child.(*envelope.StringBlock)
+
+	if !hasChild || !isString {
+		err = fmt.Errorf("missing %q string", key)
+		return
+	}
+
+	value = stringBlock.String()
+	return
+}
+
+func lookupObject(objectBlock *envelope.ObjectBlock, key string) (value *envelope.ObjectBlock, err error) {
+	var isObject bool
+
+	child, hasChild := objectBlock.Lookup(key)
+
+	if value, isObject = child.(*envelope.ObjectBlock); !hasChild || !isObject {
+		err = fmt.Errorf("missing %q object", key)
+	}
+
+	return
+}
+
+func lookupInt(objectBlock *envelope.ObjectBlock, key string) (value int64, err error) {
+	child, hasChild := objectBlock.Lookup(key)
+	intBlock, isInt := child.(*envelope.IntBlock)
+
+	if !hasChild || !isInt {
+		err = fmt.Errorf("missing %q int", key)
+		return
+	}
+
+	return intBlock.Int64()
+}
+
+// lookupMessage decodes the envelope carried under messageKey as string or binary block.
+func lookupMessage(objectBlock *envelope.ObjectBlock, options Options) (message *envelope.Envelope, err error) {
+	var data []byte
+
+	child, _ := objectBlock.Lookup(messageKey)
+
+	switch typedBlock := child.(type) {
+	case *envelope.StringBlock:
+		data = typedBlock.Value
+	case *envelope.BinaryBlock:
+		data = typedBlock.Bytes()
+	default:
+		err = fmt.Errorf("%w: missing %q envelope", block.ErrInvalidType, messageKey)
+		return
+	}
+
+	message = envelope.NewEnvelope()
+	err = message.Decode(bytes.NewReader(data), options.DecodeOptions)
+
+	return
+}
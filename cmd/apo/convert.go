@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/envelope"
+)
+
+// Both conversions read the file given as argument, or stdin when it is missing or "-",
+// and write to stdout unless an output file is set. Keys of JSON objects are always
+// converted in sorted order, as apo.ParseJSON does.
+func runConvert(args []string, stdout io.Writer) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("expected to-apo or to-json, usage: apo convert to-apo|to-json [flags] [file]")
+	}
+
+	switch args[0] {
+	case "to-apo":
+		return convertToAPO(args[1:], stdout)
+	case "to-json":
+		return convertToJSON(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown conversion %q, expected to-apo or to-json", args[0])
+	}
+}
+
+func convertToAPO(args []string, stdout io.Writer) (err error) {
+	var (
+		flags              *flag.FlagSet = flag.NewFlagSet("convert to-apo", flag.ContinueOnError)
+		output             *string       = flags.String("o", "", "write to the file instead of stdout")
+		memoryOptimization *bool         = flags.Bool("memory-optimization", false, "set EnableMemoryOptimization in the header")
+		input              []byte
+		_envelope          *envelope.Envelope
+	)
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if input, err = readInput(flags); err != nil {
+		return
+	}
+
+	if _envelope, err = apo.ParseJSON(input, envelope.Options{EnableMemoryOptimization: *memoryOptimization}); err != nil {
+		return
+	}
+
+	if *output != "" {
+		return apo.WriteFile(*output, _envelope, 0644)
+	}
+
+	// stdout is written through a buffer, which hides its Seek, so the envelope is written
+	// sequentially also when stdout is a file opened for appending by the shell
+	writer := bufio.NewWriter(stdout)
+
+	if err = _envelope.Encode(writer); err != nil {
+		return
+	}
+
+	return writer.Flush()
+}
+
+func convertToJSON(args []string, stdout io.Writer) (err error) {
+	var (
+		flags     *flag.FlagSet = flag.NewFlagSet("convert to-json", flag.ContinueOnError)
+		output    *string       = flags.String("o", "", "write to the file instead of stdout")
+		pretty    *bool         = flags.Bool("pretty", false, "indent nested values")
+		canonical *bool         = flags.Bool("canonical", false, "order keys of objects instead of keeping the order of their blocks")
+		_envelope *envelope.Envelope
+		file      *os.File
+		writer    io.Writer = stdout
+		options   envelope.JSONOptions
+	)
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() > 1 {
+		return fmt.Errorf("expected at most one file, usage: apo %s [flags] [file]", flags.Name())
+	}
+
+	if flags.NArg() == 0 || flags.Arg(0) == "-" {
+		_envelope, err = apo.Read(os.Stdin)
+	} else {
+		_envelope, err = apo.ReadFile(flags.Arg(0))
+	}
+
+	if err != nil {
+		return
+	}
+
+	if *pretty {
+		options.Indent = "  "
+	}
+
+	options.SortKeys = *canonical
+
+	if *output != "" {
+		if file, err = os.Create(*output); err != nil {
+			return
+		}
+
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+
+		writer = file
+	}
+
+	return _envelope.EncodeJSON(writer, options)
+}
+
+func readInput(flags *flag.FlagSet) (input []byte, err error) {
+	if flags.NArg() > 1 {
+		return nil, fmt.Errorf("expected at most one file, usage: apo %s [flags] [file]", flags.Name())
+	}
+
+	if flags.NArg() == 0 || flags.Arg(0) == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(flags.Arg(0))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/envelope"
+)
+
+const hexLineSize = 16
+
+func runDump(args []string, stdout io.Writer) (err error) {
+	var (
+		flags   *flag.FlagSet = flag.NewFlagSet("dump", flag.ContinueOnError)
+		isHex   *bool         = flags.Bool("hex", false, "print the bytes of every region")
+		data    []byte
+		reader  *envelope.Reader
+		regions []envelope.Region
+		offset  int64
+	)
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one file, usage: apo dump [--hex] file.apo")
+	}
+
+	if data, err = ioutil.ReadFile(flags.Arg(0)); err != nil {
+		return
+	}
+
+	if reader, err = apo.NewReaderAt(bytes.NewReader(data), int64(len(data))); err != nil {
+		return
+	}
+
+	// bytes not located by the reader, like data left by an interrupted commit, are dumped as unknown
+	for _, region := range reader.Regions() {
+		if region.Offset > offset {
+			regions = append(regions, envelope.Region{Name: "unknown", Offset: offset, Size: region.Offset - offset})
+		}
+
+		regions = append(regions, region)
+
+		if end := region.Offset + region.Size; end > offset {
+			offset = end
+		}
+	}
+
+	if int64(len(data)) > offset {
+		regions = append(regions, envelope.Region{Name: "unknown", Offset: offset, Size: int64(len(data)) - offset})
+	}
+
+	for _, region := range regions {
+		var title string = region.Name
+
+		if region.Address != 0 {
+			title = fmt.Sprintf("%s #%d", title, region.Address)
+
+			if blockIndex, hasBlockIndex := reader.Index.LookupBlockIndex(region.Address); hasBlockIndex && region.Name == "block" {
+				title = fmt.Sprintf("%s %s", title, blockIndex.Type)
+
+				if blockIndex.Key != nil {
+					title = fmt.Sprintf("%s key %v", title, blockIndex.Key)
+				}
+			}
+		}
+
+		fmt.Fprintf(stdout, "%08x  %-8d %s\n", region.Offset, region.Size, title)
+
+		if *isHex {
+			writeHex(stdout, data[region.Offset:region.Offset+region.Size], region.Offset)
+		}
+	}
+
+	return
+}
+
+// writeHex writes data in lines of hexLineSize bytes, prefixed with their offset and followed by printable characters.
+func writeHex(writer io.Writer, data []byte, offset int64) {
+	for cursor := 0; cursor < len(data); cursor += hexLineSize {
+		var (
+			line  []byte = data[cursor:]
+			hexes strings.Builder
+			chars strings.Builder
+		)
+
+		if len(line) > hexLineSize {
+			line = line[:hexLineSize]
+		}
+
+		for position := 0; position < hexLineSize; position++ {
+			if position == hexLineSize/2 {
+				hexes.WriteString(" ")
+			}
+
+			if position >= len(line) {
+				hexes.WriteString("   ")
+				continue
+			}
+
+			fmt.Fprintf(&hexes, "%02x ", line[position])
+
+			if line[position] >= 0x20 && line[position] < 0x7f {
+				chars.WriteByte(line[position])
+			} else {
+				chars.WriteByte('.')
+			}
+		}
+
+		fmt.Fprintf(writer, "    %08x  %s |%s|\n", offset+int64(cursor), hexes.String(), chars.String())
+	}
+}
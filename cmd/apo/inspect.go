@@ -0,0 +1,309 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/envelope"
+	"github.com/deitas/apo/index"
+)
+
+const maxValueLength = 48
+
+func runInspect(args []string, stdout io.Writer) (err error) {
+	var (
+		flags  *flag.FlagSet = flag.NewFlagSet("inspect", flag.ContinueOnError)
+		reader *envelope.Reader
+	)
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one file, usage: apo inspect file.apo")
+	}
+
+	if reader, err = apo.Open(flags.Arg(0)); err != nil {
+		return
+	}
+
+	defer reader.Close()
+
+	writer := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+
+	if err = printHeader(writer, reader); err != nil {
+		return
+	}
+
+	printIndexStatistics(writer, reader)
+
+	if err = writer.Flush(); err != nil {
+		return
+	}
+
+	fmt.Fprintln(stdout)
+
+	return printTree(stdout, reader)
+}
+
+func printHeader(writer io.Writer, reader *envelope.Reader) (err error) {
+	var (
+		checksums []envelope.SectionChecksum
+		flagNames []string
+		segments  int
+	)
+
+	for _, flagName := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"extension", reader.Header.IsExtension},
+		{"memory optimization", reader.Header.EnableMemoryOptimization},
+		{"trailer index", reader.Header.HasTrailerIndex},
+		{"journal", reader.Header.HasJournal},
+		{"metadata", reader.Header.HasMetadata},
+	} {
+		if flagName.enabled {
+			flagNames = append(flagNames, flagName.name)
+		}
+	}
+
+	if len(flagNames) == 0 {
+		flagNames = []string{"none"}
+	}
+
+	fmt.Fprintf(writer, "version\t%s\n", reader.Header.Version)
+	fmt.Fprintf(writer, "flags\t%s\n", strings.Join(flagNames, ", "))
+	fmt.Fprintf(writer, "address bytes\t%d\n", reader.Header.AddressBytes)
+
+	if checksums, err = reader.Checksums(); err != nil {
+		return
+	}
+
+	for cursor, checksum := range checksums {
+		var (
+			name   string = checksum.Section.String() + " checksum"
+			status string = "ok"
+		)
+
+		// checksums come in pairs, the first one of the envelope and one per journal segment
+		if cursor >= 2 {
+			segments = cursor / 2
+			name = fmt.Sprintf("segment %d %s", segments, name)
+		}
+
+		if !checksum.IsValid() {
+			status = fmt.Sprintf("MISMATCH, calculated %x", checksum.Calculated.Value)
+		}
+
+		fmt.Fprintf(writer, "%s\t%x %s\n", name, checksum.Stored.Value, status)
+	}
+
+	if reader.Header.HasJournal {
+		fmt.Fprintf(writer, "journal segments\t%d\n", segments)
+	}
+
+	if metadata := reader.Metadata; metadata != nil {
+		var keys []string
+
+		if !metadata.CreatedAt.IsZero() {
+			fmt.Fprintf(writer, "created at\t%s\n", metadata.CreatedAt.UTC().Format(time.RFC3339Nano))
+		}
+
+		for _, field := range [][2]string{
+			{"producer", metadata.Producer},
+			{"content type", metadata.ContentType},
+			{"schema ID", metadata.SchemaID},
+		} {
+			if field[1] != "" {
+				fmt.Fprintf(writer, "%s\t%s\n", field[0], field[1])
+			}
+		}
+
+		for key := range metadata.Attributes {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(writer, "attribute %s\t%s\n", key, metadata.Attributes[key])
+		}
+	}
+
+	return
+}
+
+func printIndexStatistics(writer io.Writer, reader *envelope.Reader) {
+	var (
+		types     map[block.BlockType]int = map[block.BlockType]int{}
+		typeNames []string
+		size      int64
+		requests  int
+		responses int
+	)
+
+	for _, address := range reader.Index.AllocatedAddresses {
+		blockIndex, _ := reader.Index.LookupBlockIndex(address)
+
+		types[blockIndex.Type]++
+		size += int64(blockIndex.BlockSize)
+
+		if blockIndex.HasFlag(index.BitmaskRequest) {
+			requests++
+		}
+
+		if blockIndex.HasFlag(index.BitmaskResponse) {
+			responses++
+		}
+	}
+
+	for blockType, count := range types {
+		typeNames = append(typeNames, fmt.Sprintf("%s %d", blockType, count))
+	}
+
+	sort.Strings(typeNames)
+
+	fmt.Fprintf(writer, "blocks\t%d (%s)\n", len(reader.Index.AllocatedAddresses), strings.Join(typeNames, ", "))
+	fmt.Fprintf(writer, "block bytes\t%d\n", size)
+	fmt.Fprintf(writer, "requests\t%d\n", requests)
+	fmt.Fprintf(writer, "responses\t%d\n", responses)
+}
+
+// printTree prints every block which is not a child of an object block with the tree below it.
+// Object blocks referenced several times are printed once and referred to as "-> #address" afterwards.
+func printTree(writer io.Writer, reader *envelope.Reader) (err error) {
+	var (
+		isChild   map[block.BlockAddress]bool = map[block.BlockAddress]bool{}
+		isPrinted map[block.BlockAddress]bool = map[block.BlockAddress]bool{}
+	)
+
+	// blocks are loaded upfront, so object blocks link their children
+	for _, address := range reader.Index.AllocatedAddresses {
+		var loadedBlock block.Block
+
+		if loadedBlock, err = reader.Block(address); err != nil {
+			return
+		}
+
+		if objectBlock, isObject := loadedBlock.(*envelope.ObjectBlock); isObject {
+			for _, childAddress := range objectBlock.Values {
+				isChild[childAddress] = true
+			}
+		}
+	}
+
+	for _, address := range reader.Index.AllocatedAddresses {
+		if isChild[address] {
+			continue
+		}
+
+		var rootBlock block.Block
+
+		if rootBlock, err = reader.Block(address); err != nil {
+			return
+		}
+
+		if err = reader.Walk(rootBlock, func(path []interface{}, depth int, current block.Block, blockIndex *index.BlockIndex) error {
+			var label string = fmt.Sprintf("#%d", current.Address())
+
+			if depth > 0 {
+				label = fmt.Sprint(path[len(path)-1])
+			}
+
+			if _, isObject := current.(*envelope.ObjectBlock); isObject {
+				if isPrinted[current.Address()] {
+					fmt.Fprintf(writer, "%s%s: -> #%d\n", strings.Repeat("  ", depth), label, current.Address())
+					return envelope.SkipChildren
+				}
+
+				isPrinted[current.Address()] = true
+			}
+
+			fmt.Fprintf(writer, "%s%s: %s\n", strings.Repeat("  ", depth), label, describeBlock(current, blockIndex))
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func describeBlock(current block.Block, blockIndex *index.BlockIndex) string {
+	var (
+		builder   strings.Builder
+		blockType string = current.Type().String()
+	)
+
+	switch typedBlock := current.(type) {
+	case *envelope.ObjectBlock:
+		if typedBlock.IsArray() {
+			blockType = "Array"
+		}
+
+		fmt.Fprintf(&builder, "%s (%d)", blockType, len(typedBlock.Values))
+	case *envelope.AddressBlock:
+		fmt.Fprintf(&builder, "%s -> #%d", blockType, typedBlock.Value)
+	case *envelope.BinaryBlock:
+		fmt.Fprintf(&builder, "%s %d bytes", blockType, typedBlock.Size())
+	case *envelope.StringBlock:
+		fmt.Fprintf(&builder, "%s %s", blockType, truncate(fmt.Sprintf("%q", typedBlock.Value)))
+	default:
+		value, err := current.Interface()
+
+		if err != nil {
+			fmt.Fprintf(&builder, "%s <%s>", blockType, err)
+			break
+		}
+
+		if value == nil {
+			builder.WriteString(blockType)
+			break
+		}
+
+		fmt.Fprintf(&builder, "%s %s", blockType, truncate(fmt.Sprint(value)))
+	}
+
+	if blockIndex != nil {
+		fmt.Fprintf(&builder, " [%d B]", blockIndex.BlockSize)
+	}
+
+	if current.IsRequest() {
+		builder.WriteString(" request")
+	}
+
+	if current.IsResponse() {
+		builder.WriteString(" response")
+	}
+
+	return builder.String()
+}
+
+// truncate shortens value to maxValueLength runes, so multi-byte characters are not cut.
+func truncate(value string) string {
+	var count int
+
+	if utf8.RuneCountInString(value) <= maxValueLength {
+		return value
+	}
+
+	for offset := range value {
+		if count == maxValueLength-3 {
+			return value[:offset] + "..."
+		}
+
+		count++
+	}
+
+	return value
+}
@@ -0,0 +1,71 @@
+// Command apo inspects APO files and converts them from and to JSON.
+//
+//	apo inspect file.apo		header, checksums, index statistics and block tree
+//	apo dump [--hex] file.apo	regions of the encoded data, with an annotated hexdump
+//	apo convert to-apo [file.json]	JSON to APO, see apo convert to-apo -h
+//	apo convert to-json [file.apo]	APO to JSON, see apo convert to-json -h
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+type command struct {
+	name    string
+	usage   string
+	run     func(args []string, stdout io.Writer) error
+	summary string
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "inspect", usage: "apo inspect file.apo", run: runInspect, summary: "print the header, checksums, index statistics and block tree"},
+		{name: "dump", usage: "apo dump [--hex] file.apo", run: runDump, summary: "print the regions of the encoded data"},
+		{name: "convert", usage: "apo convert to-apo [file.json]", run: runConvert, summary: "convert JSON to APO"},
+		{name: "convert", usage: "apo convert to-json [file.apo]", run: runConvert, summary: "convert APO to JSON"},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	for _, current := range commands {
+		if current.name != os.Args[1] {
+			continue
+		}
+
+		// flag sets already printed their usage when help was requested
+		if err := current.run(os.Args[2:], os.Stdout); err == flag.ErrHelp {
+			os.Exit(2)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "apo %s: %s\n", current.name, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "--help" {
+		fmt.Fprintf(os.Stderr, "apo: unknown command %q\n\n", os.Args[1])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	usage(os.Stdout)
+}
+
+func usage(writer io.Writer) {
+	fmt.Fprintln(writer, "Usage:")
+
+	for _, current := range commands {
+		fmt.Fprintf(writer, "\t%-32s %s\n", current.usage, current.summary)
+	}
+}
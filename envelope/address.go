@@ -1,7 +1,6 @@
 package envelope
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
 
@@ -20,37 +19,11 @@ func (envelope *Envelope) decodeAddress(address block.BlockAddress, buffer []byt
 	}
 
 	if len(buffer) != envelope.Header.AddressBytes {
-		err = fmt.Errorf("invalid address size")
+		err = fmt.Errorf("%w: address must be %d bytes, got %d", block.ErrInvalidSize, envelope.Header.AddressBytes, len(buffer))
 		return
 	}
 
-	var addressBuffer []byte
-	switch envelope.Header.AddressBytes {
-	case 1:
-		addressBuffer = []byte{buffer[0], 0x0}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-	case 2:
-		addressBuffer = []byte{buffer[0], buffer[1]}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-	case 3:
-		addressBuffer = []byte{buffer[0], buffer[1], buffer[2], 0x0}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-	case 4:
-		addressBuffer = []byte{buffer[0], buffer[1], buffer[2], buffer[3]}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-	case 5:
-		addressBuffer = []byte{buffer[0], buffer[1], buffer[2], buffer[3], buffer[4], 0x0, 0x0, 0x0}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	case 6:
-		addressBuffer = []byte{buffer[0], buffer[1], buffer[2], buffer[3], buffer[4], buffer[5], 0x0, 0x0}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	case 7:
-		addressBuffer = []byte{buffer[0], buffer[1], buffer[2], buffer[3], buffer[4], buffer[5], buffer[6], 0x0}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	case 8:
-		addressBuffer = []byte{buffer[0], buffer[1], buffer[2], buffer[3], buffer[4], buffer[5], buffer[6], buffer[7]}
-		addressBlock.Value = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	}
+	addressBlock.Value, err = block.DecodeBlockAddress(buffer, envelope.Header.AddressBytes)
 
 	return
 }
@@ -80,7 +53,7 @@ type AddressBlock struct {
 }
 
 func (addressBlock *AddressBlock) Type() block.BlockType {
-	return block.Boolean
+	return block.Address
 }
 
 func (addressBlock *AddressBlock) Address() block.BlockAddress {
@@ -100,6 +73,10 @@ func (addressBlock *AddressBlock) SetKey(key interface{}) error {
 	return addressBlock.envelope.Index.SetKey(addressBlock.address, key)
 }
 
+func (addressBlock *AddressBlock) Parents() []block.Block {
+	return addressBlock.envelope.lookupParents(addressBlock.address)
+}
+
 func (addressBlock *AddressBlock) IsRequest() bool {
 	return addressBlock.envelope.Index.HasFlag(addressBlock.address, index.BitmaskRequest)
 }
@@ -128,6 +105,44 @@ func (addressBlock *AddressBlock) SetIsResponse(isResponse bool) block.Block {
 	return addressBlock
 }
 
+// Target resolves the block referenced by the address block, following chained address blocks.
+func (addressBlock *AddressBlock) Target() (target block.Block, err error) {
+	var (
+		hasTarget bool
+		visited   map[block.BlockAddress]bool = map[block.BlockAddress]bool{addressBlock.address: true}
+		current   *AddressBlock               = addressBlock
+	)
+
+	for {
+		if target, hasTarget, err = addressBlock.envelope.lookupBlock(current.Value); err != nil {
+			return
+		}
+
+		if !hasTarget {
+			err = fmt.Errorf("block with address %d does not exist", current.Value)
+			return
+		}
+
+		if visited[target.Address()] {
+			err = fmt.Errorf("cyclic reference to block with address %d", target.Address())
+			return
+		}
+
+		visited[target.Address()] = true
+
+		if next, isAddress := target.(*AddressBlock); isAddress {
+			current = next
+			continue
+		}
+
+		return
+	}
+}
+
+func (addressBlock *AddressBlock) Interface() (interface{}, error) {
+	return addressBlock.envelope.resolveInterface(addressBlock, newTraversal(addressBlock.envelope))
+}
+
 func (addressBlock *AddressBlock) Encode(writer io.Writer) (n int, err error) {
 	var (
 		addressData []byte
@@ -1,6 +1,8 @@
 package envelope
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -20,6 +22,42 @@ func (envelope *Envelope) decodeBinary(address block.BlockAddress, buffer []byte
 	return
 }
 
+func (envelope *Envelope) AddBinary(input interface{}) (binaryBlock *BinaryBlock, err error) {
+	binaryBlock = &BinaryBlock{
+		envelope: envelope,
+	}
+
+	switch value := input.(type) {
+	case []byte:
+		binaryBlock.Data = value
+	default:
+		err = fmt.Errorf("invalid value type: %T", value)
+		return
+	}
+
+	err = envelope.allocateBlock(binaryBlock)
+
+	return
+}
+
+// AddBinaryReaderAt adds a binary block reading its size bytes from readerAt when the block
+// is encoded, so large data is not held in memory. readerAt must stay readable until then.
+func (envelope *Envelope) AddBinaryReaderAt(readerAt io.ReaderAt, size int64) (binaryBlock *BinaryBlock, err error) {
+	if size < 0 || size >= 4294967296 {
+		err = fmt.Errorf("binary data of %d bytes exceeds maximum block size of 4 GiB", size)
+		return
+	}
+
+	binaryBlock = &BinaryBlock{
+		envelope: envelope,
+		source:   io.NewSectionReader(readerAt, 0, size),
+	}
+
+	err = envelope.allocateBlock(binaryBlock)
+
+	return
+}
+
 func (envelope *Envelope) AddFile(name string) (binaryBlock *BinaryBlock, err error) {
 	var (
 		file     *os.File
@@ -55,6 +93,7 @@ type BinaryBlock struct {
 	MIME     string
 	Name     string
 	Data     []byte
+	source   *io.SectionReader
 }
 
 func (binaryBlock *BinaryBlock) Type() block.BlockType {
@@ -78,6 +117,10 @@ func (binaryBlock *BinaryBlock) SetKey(key interface{}) error {
 	return binaryBlock.envelope.Index.SetKey(binaryBlock.address, key)
 }
 
+func (binaryBlock *BinaryBlock) Parents() []block.Block {
+	return binaryBlock.envelope.lookupParents(binaryBlock.address)
+}
+
 func (binaryBlock *BinaryBlock) IsRequest() bool {
 	return binaryBlock.envelope.Index.HasFlag(binaryBlock.address, index.BitmaskRequest)
 }
@@ -106,12 +149,77 @@ func (binaryBlock *BinaryBlock) SetIsResponse(isResponse bool) block.Block {
 	return binaryBlock
 }
 
+// Bytes returns the data, reading it first for blocks added by AddBinaryReaderAt.
+// Read failures of such blocks are reported by Interface and Encode only.
+func (binaryBlock *BinaryBlock) Bytes() []byte {
+	data, _ := binaryBlock.readAll()
+	return data
+}
+
+// Reader returns a reader of the data, which streams the data of blocks added by AddBinaryReaderAt.
+func (binaryBlock *BinaryBlock) Reader() io.Reader {
+	if binaryBlock.source != nil {
+		return io.NewSectionReader(binaryBlock.source, 0, binaryBlock.source.Size())
+	}
+
+	return bytes.NewReader(binaryBlock.Data)
+}
+
+// Size returns the number of bytes of the data.
+func (binaryBlock *BinaryBlock) Size() int64 {
+	if binaryBlock.source != nil {
+		return binaryBlock.source.Size()
+	}
+
+	return int64(len(binaryBlock.Data))
+}
+
+func (binaryBlock *BinaryBlock) readAll() (data []byte, err error) {
+	if binaryBlock.source == nil {
+		return binaryBlock.Data, nil
+	}
+
+	data = make([]byte, binaryBlock.source.Size())
+
+	if read, readErr := binaryBlock.source.ReadAt(data, 0); read == len(data) {
+		err = nil
+	} else if readErr == io.EOF {
+		err = io.ErrUnexpectedEOF
+	} else {
+		err = readErr
+	}
+
+	return
+}
+
+func (binaryBlock *BinaryBlock) Interface() (value interface{}, err error) {
+	return binaryBlock.readAll()
+}
+
 func (binaryBlock *BinaryBlock) Encode(writer io.Writer) (n int, err error) {
-	var addressData []byte
+	var (
+		addressData []byte
+		written     int64
+	)
 
 	if addressData, err = binaryBlock.address.ToBytes(binaryBlock.envelope.Header.AddressBytes); err != nil {
 		return
 	}
 
-	return writer.Write(append(addressData, binaryBlock.Data...))
+	if binaryBlock.source == nil {
+		return writer.Write(append(addressData, binaryBlock.Data...))
+	}
+
+	if n, err = writer.Write(addressData); err != nil {
+		return
+	}
+
+	written, err = io.Copy(writer, binaryBlock.Reader())
+	n += int(written)
+
+	if err == nil && written != binaryBlock.source.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return
 }
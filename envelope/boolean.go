@@ -18,6 +18,13 @@ func (envelope *Envelope) decodeBoolean(address block.BlockAddress, buffer []byt
 		address:  address,
 	}
 
+	if len(buffer) != 1 {
+		err = fmt.Errorf("%w: boolean must be 1 byte, got %d", block.ErrInvalidSize, len(buffer))
+		return
+	}
+
+	booleanBlock.Value = buffer[0]
+
 	return
 }
 
@@ -71,6 +78,10 @@ func (booleanBlock *BooleanBlock) SetKey(key interface{}) error {
 	return booleanBlock.envelope.Index.SetKey(booleanBlock.address, key)
 }
 
+func (booleanBlock *BooleanBlock) Parents() []block.Block {
+	return booleanBlock.envelope.lookupParents(booleanBlock.address)
+}
+
 func (booleanBlock *BooleanBlock) IsRequest() bool {
 	return booleanBlock.envelope.Index.HasFlag(booleanBlock.address, index.BitmaskRequest)
 }
@@ -99,6 +110,14 @@ func (booleanBlock *BooleanBlock) SetIsResponse(isResponse bool) block.Block {
 	return booleanBlock
 }
 
+func (booleanBlock *BooleanBlock) Bool() bool {
+	return booleanBlock.Value != 0x0
+}
+
+func (booleanBlock *BooleanBlock) Interface() (interface{}, error) {
+	return booleanBlock.Bool(), nil
+}
+
 func (booleanBlock *BooleanBlock) Encode(writer io.Writer) (n int, err error) {
 	var addressData []byte
 
@@ -0,0 +1,337 @@
+package envelope
+
+import (
+	"github.com/deitas/apo/block"
+)
+
+// Builder constructs blocks of an envelope directly, without the reflection used by ParseBlock.
+// Child blocks are allocated before their object block, the same order ParseBlock produces.
+type Builder struct {
+	envelope *Envelope
+	err      error
+}
+
+func NewBuilder(options ...Options) *Builder {
+	return NewEnvelope(options...).Builder()
+}
+
+func (envelope *Envelope) Builder() *Builder {
+	return &Builder{
+		envelope: envelope,
+	}
+}
+
+func (builder *Builder) Envelope() *Envelope {
+	return builder.envelope
+}
+
+// Err returns the first error that occurred while building.
+func (builder *Builder) Err() error {
+	return builder.err
+}
+
+// Object builds an object block, blocks allocated by a build failing halfway are removed again.
+func (builder *Builder) Object(build func(*ObjectBuilder)) (objectBlock *ObjectBlock, err error) {
+	var allocated int = len(builder.envelope.Index.AllocatedAddresses)
+
+	if objectBlock, err = builder.buildObject(build); err != nil {
+		builder.envelope.releaseBlocks(allocated)
+	}
+
+	return
+}
+
+// Array builds an object block flagged as array, like Object.
+func (builder *Builder) Array(build func(*ArrayBuilder)) (objectBlock *ObjectBlock, err error) {
+	var allocated int = len(builder.envelope.Index.AllocatedAddresses)
+
+	if objectBlock, err = builder.buildArray(build); err != nil {
+		builder.envelope.releaseBlocks(allocated)
+	}
+
+	return
+}
+
+func (builder *Builder) buildObject(build func(*ObjectBuilder)) (objectBlock *ObjectBlock, err error) {
+	var objectBuilder *ObjectBuilder = &ObjectBuilder{
+		values: valuesBuilder{builder: builder},
+	}
+
+	if build != nil {
+		build(objectBuilder)
+	}
+
+	return objectBuilder.values.build(false)
+}
+
+func (builder *Builder) buildArray(build func(*ArrayBuilder)) (objectBlock *ObjectBlock, err error) {
+	var arrayBuilder *ArrayBuilder = &ArrayBuilder{
+		values: valuesBuilder{builder: builder},
+	}
+
+	if build != nil {
+		build(arrayBuilder)
+	}
+
+	return arrayBuilder.values.build(true)
+}
+
+type valuesBuilder struct {
+	builder   *Builder
+	addresses []block.BlockAddress
+}
+
+func (values *valuesBuilder) add(key interface{}, valueBlock block.Block, err error) {
+	if values.builder.err != nil {
+		return
+	}
+
+	if err != nil {
+		values.builder.err = err
+		return
+	}
+
+	if err = valueBlock.SetKey(key); err != nil {
+		values.builder.err = err
+		return
+	}
+
+	values.addresses = append(values.addresses, valueBlock.Address())
+}
+
+func (values *valuesBuilder) build(isArray bool) (objectBlock *ObjectBlock, err error) {
+	if err = values.builder.err; err != nil {
+		return
+	}
+
+	if objectBlock, err = values.builder.envelope.AddObject(values.addresses); err != nil {
+		values.builder.err = err
+		return
+	}
+
+	if isArray {
+		objectBlock.SetIsArray(true)
+	}
+
+	return
+}
+
+func (values *valuesBuilder) addEmpty(key interface{}) {
+	if values.builder.err != nil {
+		return
+	}
+
+	emptyBlock, err := values.builder.envelope.AddEmpty()
+	values.add(key, emptyBlock, err)
+}
+
+func (values *valuesBuilder) addString(key interface{}, value string) {
+	if values.builder.err != nil {
+		return
+	}
+
+	stringBlock, err := values.builder.envelope.AddString(value)
+	values.add(key, stringBlock, err)
+}
+
+func (values *valuesBuilder) addBinary(key interface{}, value []byte) {
+	if values.builder.err != nil {
+		return
+	}
+
+	binaryBlock, err := values.builder.envelope.AddBinary(value)
+	values.add(key, binaryBlock, err)
+}
+
+func (values *valuesBuilder) addInt(key interface{}, value int64) {
+	if values.builder.err != nil {
+		return
+	}
+
+	intBlock, err := values.builder.envelope.AddInt(value)
+	values.add(key, intBlock, err)
+}
+
+func (values *valuesBuilder) addUint(key interface{}, value uint64) {
+	if values.builder.err != nil {
+		return
+	}
+
+	intBlock, err := values.builder.envelope.AddInt(value)
+	values.add(key, intBlock, err)
+}
+
+func (values *valuesBuilder) addFloat(key interface{}, value float64) {
+	if values.builder.err != nil {
+		return
+	}
+
+	floatBlock, err := values.builder.envelope.AddFloat(value)
+	values.add(key, floatBlock, err)
+}
+
+func (values *valuesBuilder) addBool(key interface{}, value bool) {
+	if values.builder.err != nil {
+		return
+	}
+
+	booleanBlock, err := values.builder.envelope.AddBoolean(value)
+	values.add(key, booleanBlock, err)
+}
+
+func (values *valuesBuilder) addAddress(key interface{}, value block.BlockAddress) {
+	if values.builder.err != nil {
+		return
+	}
+
+	addressBlock, err := values.builder.envelope.AddAddress(value)
+	values.add(key, addressBlock, err)
+}
+
+func (values *valuesBuilder) addObject(key interface{}, build func(*ObjectBuilder)) {
+	if values.builder.err != nil {
+		return
+	}
+
+	objectBlock, err := values.builder.buildObject(build)
+	values.add(key, objectBlock, err)
+}
+
+func (values *valuesBuilder) addArray(key interface{}, build func(*ArrayBuilder)) {
+	if values.builder.err != nil {
+		return
+	}
+
+	objectBlock, err := values.builder.buildArray(build)
+	values.add(key, objectBlock, err)
+}
+
+type ObjectBuilder struct {
+	values valuesBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Empty(key string) *ObjectBuilder {
+	objectBuilder.values.addEmpty(key)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) String(key string, value string) *ObjectBuilder {
+	objectBuilder.values.addString(key, value)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Binary(key string, value []byte) *ObjectBuilder {
+	objectBuilder.values.addBinary(key, value)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Int(key string, value int64) *ObjectBuilder {
+	objectBuilder.values.addInt(key, value)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Uint(key string, value uint64) *ObjectBuilder {
+	objectBuilder.values.addUint(key, value)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Float(key string, value float64) *ObjectBuilder {
+	objectBuilder.values.addFloat(key, value)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Bool(key string, value bool) *ObjectBuilder {
+	objectBuilder.values.addBool(key, value)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Address(key string, value block.BlockAddress) *ObjectBuilder {
+	objectBuilder.values.addAddress(key, value)
+	return objectBuilder
+}
+
+// Block adds an already allocated block under key.
+func (objectBuilder *ObjectBuilder) Block(key string, value block.Block) *ObjectBuilder {
+	if objectBuilder.values.builder.err == nil {
+		objectBuilder.values.add(key, value, nil)
+	}
+
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Object(key string, build func(*ObjectBuilder)) *ObjectBuilder {
+	objectBuilder.values.addObject(key, build)
+	return objectBuilder
+}
+
+func (objectBuilder *ObjectBuilder) Array(key string, build func(*ArrayBuilder)) *ObjectBuilder {
+	objectBuilder.values.addArray(key, build)
+	return objectBuilder
+}
+
+type ArrayBuilder struct {
+	values valuesBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) nextKey() int {
+	return len(arrayBuilder.values.addresses)
+}
+
+func (arrayBuilder *ArrayBuilder) Empty() *ArrayBuilder {
+	arrayBuilder.values.addEmpty(arrayBuilder.nextKey())
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) String(value string) *ArrayBuilder {
+	arrayBuilder.values.addString(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Binary(value []byte) *ArrayBuilder {
+	arrayBuilder.values.addBinary(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Int(value int64) *ArrayBuilder {
+	arrayBuilder.values.addInt(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Uint(value uint64) *ArrayBuilder {
+	arrayBuilder.values.addUint(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Float(value float64) *ArrayBuilder {
+	arrayBuilder.values.addFloat(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Bool(value bool) *ArrayBuilder {
+	arrayBuilder.values.addBool(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Address(value block.BlockAddress) *ArrayBuilder {
+	arrayBuilder.values.addAddress(arrayBuilder.nextKey(), value)
+	return arrayBuilder
+}
+
+// Block appends an already allocated block.
+func (arrayBuilder *ArrayBuilder) Block(value block.Block) *ArrayBuilder {
+	if arrayBuilder.values.builder.err == nil {
+		arrayBuilder.values.add(arrayBuilder.nextKey(), value, nil)
+	}
+
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Object(build func(*ObjectBuilder)) *ArrayBuilder {
+	arrayBuilder.values.addObject(arrayBuilder.nextKey(), build)
+	return arrayBuilder
+}
+
+func (arrayBuilder *ArrayBuilder) Array(build func(*ArrayBuilder)) *ArrayBuilder {
+	arrayBuilder.values.addArray(arrayBuilder.nextKey(), build)
+	return arrayBuilder
+}
@@ -0,0 +1,532 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+	"github.com/deitas/apo/index"
+)
+
+// Decoder reads an envelope from a stream: the header, then the index using its
+// declared size, then one block at a time. Decoded blocks are not kept in the
+// envelope, so the caller can process and discard them while they arrive.
+//
+// Blocks of envelopes with a trailer index cannot be decoded before the index,
+// so they are kept as raw frames until the trailer is read. Blocks of journal
+// segments follow, including blocks replacing ones that were already returned,
+// each segment is kept as frames until its footer is read.
+type Decoder struct {
+	envelope      *Envelope
+	reader        io.Reader
+	options       DecodeOptions
+	offset        int64
+	isIndexRead   bool
+	blocksPending int
+	frames        []trailerFrame
+	segments      int
+	// isInterrupted is set once a segment left by an interrupted commit was found
+	isInterrupted bool
+}
+
+type trailerFrame struct {
+	offset int64
+	data   []byte
+}
+
+func NewDecoder(reader io.Reader, options ...DecodeOptions) *Decoder {
+	return newDecoder(NewEnvelope(), reader, options...)
+}
+
+func newDecoder(envelope *Envelope, reader io.Reader, options ...DecodeOptions) (decoder *Decoder) {
+	decoder = &Decoder{
+		envelope: envelope,
+		reader:   reader,
+	}
+
+	if len(options) > 0 {
+		decoder.options = options[0]
+	}
+
+	return
+}
+
+// Envelope returns the envelope holding the decoded header and index.
+// Blocks returned by Next refer to it for their keys and flags.
+func (decoder *Decoder) Envelope() *Envelope {
+	return decoder.envelope
+}
+
+// Offset returns the number of bytes consumed from the reader.
+func (decoder *Decoder) Offset() int64 {
+	return decoder.offset
+}
+
+func (decoder *Decoder) read(size int64, section block.Section) (data []byte, err error) {
+	var (
+		buffer  bytes.Buffer
+		written int64
+	)
+
+	if decoder.options.MaxSize > 0 && decoder.offset+size > decoder.options.MaxSize {
+		err = &block.DecodeError{
+			Offset:  decoder.offset,
+			Section: section,
+			Err:     fmt.Errorf("%w: data is larger than %d bytes", block.ErrLimitExceeded, decoder.options.MaxSize),
+		}
+		return
+	}
+
+	// the buffer grows with the data actually received, so a forged size cannot allocate memory upfront
+	written, err = io.CopyN(&buffer, decoder.reader, size)
+	decoder.offset += written
+
+	if err == io.EOF {
+		err = &block.DecodeError{
+			Offset:  decoder.offset,
+			Section: section,
+			Err:     block.ErrTruncated,
+		}
+	}
+
+	data = buffer.Bytes()
+	return
+}
+
+// DecodeIndex reads the header and the index, it is called by Next when needed.
+func (decoder *Decoder) DecodeIndex() (err error) {
+	var (
+		headerData  []byte
+		sizeData    []byte
+		indexOffset int64
+		indexData   []byte
+	)
+
+	if decoder.isIndexRead {
+		return
+	}
+
+	if headerData, err = decoder.read(26, block.SectionHeader); err != nil {
+		return
+	}
+
+	if err = decoder.envelope.Header.Decode(headerData); err != nil {
+		return
+	}
+
+	if err = decoder.envelope.useVersion(); err != nil {
+		return
+	}
+
+	if decoder.envelope.Header.HasMetadata {
+		if err = decoder.decodeMetadata(); err != nil {
+			return
+		}
+	}
+
+	if decoder.envelope.Header.HasTrailerIndex {
+		return decoder.decodeTrailer()
+	}
+
+	if sizeData, err = decoder.read(4, block.SectionIndex); err != nil {
+		return
+	}
+
+	indexOffset = decoder.offset
+
+	if indexData, err = decoder.read(int64(binary.LittleEndian.Uint32(sizeData)), block.SectionIndex); err != nil {
+		return
+	}
+
+	if err = decoder.envelope.Index.DecodeEntries(decoder.envelope.Header, indexData, indexOffset, decoder.options.indexLimits()); err != nil {
+		return
+	}
+
+	if err = decoder.envelope.Index.CheckAddresses(); err != nil {
+		return
+	}
+
+	decoder.isIndexRead = true
+	decoder.blocksPending = len(decoder.envelope.Index.AllocatedAddresses)
+
+	return
+}
+
+// decodeMetadata reads the metadata section following the header.
+func (decoder *Decoder) decodeMetadata() (err error) {
+	var (
+		sizeData     []byte
+		metadataData []byte
+	)
+
+	if sizeData, err = decoder.read(4, block.SectionHeader); err != nil {
+		return
+	}
+
+	if metadataData, err = decoder.read(int64(binary.LittleEndian.Uint32(sizeData)), block.SectionHeader); err != nil {
+		return
+	}
+
+	decoder.envelope.Metadata, err = header.DecodeMetadata(metadataData)
+	return
+}
+
+func (decoder *Decoder) decodeTrailer() (err error) {
+	var (
+		sizeData    []byte
+		frameSize   uint32
+		frameOffset int64
+		frameData   []byte
+		indexOffset int64
+		indexData   []byte
+		footerData  []byte
+	)
+
+	for {
+		if sizeData, err = decoder.read(4, block.SectionBlocks); err != nil {
+			return
+		}
+
+		if frameSize = binary.LittleEndian.Uint32(sizeData); frameSize == 0 {
+			break
+		}
+
+		frameOffset = decoder.offset
+
+		if decoder.options.MaxBlocks > 0 && len(decoder.frames) >= decoder.options.MaxBlocks {
+			err = &block.DecodeError{
+				Offset:  frameOffset,
+				Section: block.SectionBlocks,
+				Err:     fmt.Errorf("%w: more than %d blocks", block.ErrLimitExceeded, decoder.options.MaxBlocks),
+			}
+			return
+		}
+
+		if decoder.options.MaxBlockSize > 0 && frameSize > decoder.options.MaxBlockSize {
+			err = &block.DecodeError{
+				Offset:  frameOffset,
+				Section: block.SectionBlocks,
+				Err:     fmt.Errorf("%w: block size %d is larger than %d bytes", block.ErrLimitExceeded, frameSize, decoder.options.MaxBlockSize),
+			}
+			return
+		}
+
+		if frameData, err = decoder.read(int64(frameSize), block.SectionBlocks); err != nil {
+			return
+		}
+
+		decoder.frames = append(decoder.frames, trailerFrame{offset: frameOffset, data: frameData})
+	}
+
+	if sizeData, err = decoder.read(4, block.SectionIndex); err != nil {
+		return
+	}
+
+	indexOffset = decoder.offset
+
+	if indexData, err = decoder.read(int64(binary.LittleEndian.Uint32(sizeData)), block.SectionIndex); err != nil {
+		return
+	}
+
+	if footerData, err = decoder.read(trailerFooterSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if binary.LittleEndian.Uint32(footerData[16:20]) != binary.LittleEndian.Uint32(sizeData) {
+		err = &block.DecodeError{
+			Offset:  decoder.offset - 4,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: trailer index sizes do not match", block.ErrInvalidSize),
+		}
+		return
+	}
+
+	if err = decoder.envelope.Index.DecodeEntries(decoder.envelope.Header, indexData, indexOffset, decoder.options.indexLimits()); err != nil {
+		return
+	}
+
+	if err = decoder.envelope.Index.CheckAddresses(); err != nil {
+		return
+	}
+
+	if len(decoder.frames) != len(decoder.envelope.Index.AllocatedAddresses) {
+		err = &block.DecodeError{
+			Offset:  indexOffset,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: index declares %d blocks, found %d", block.ErrInvalidSize, len(decoder.envelope.Index.AllocatedAddresses), len(decoder.frames)),
+		}
+		return
+	}
+
+	decoder.envelope.Header.IndexChecksum = header.Checksum{Value: footerData[0:8]}
+	decoder.envelope.Header.BlocksChecksum = header.Checksum{Value: footerData[8:16]}
+
+	decoder.isIndexRead = true
+	decoder.blocksPending = len(decoder.frames)
+
+	return
+}
+
+// nextSegment reads the following journal segment, keeping its blocks as frames until its
+// footer is read. It returns io.EOF after the last segment. As Reader does, decoding stops at
+// the last intact footer: a segment following the first one which ends or breaks before its
+// footer was left by an interrupted commit, so it is ignored together with the data after it.
+func (decoder *Decoder) nextSegment() (err error) {
+	var (
+		segmentOffset int64
+		segmentData   []byte
+		segment       journalSegment
+		indexData     []byte
+		delta         *index.Index
+		blocksSize    int64
+		blocksOffset  int64
+		blocksData    []byte
+		footerData    []byte
+		isIntact      bool
+		decodeError   *block.DecodeError
+	)
+
+	defer func() {
+		if decoder.segments > 0 && !isIntact && errors.As(err, &decodeError) && !errors.Is(err, block.ErrLimitExceeded) {
+			decoder.isInterrupted = true
+			err = io.EOF
+		}
+	}()
+
+	segmentOffset = decoder.offset
+
+	if segmentData, err = decoder.read(segmentHeaderSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if segment, err = decodeJournalSegment(segmentOffset, segmentData); err != nil {
+		return
+	}
+
+	if indexData, err = decoder.read(segment.indexSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if delta, err = decoder.envelope.decodeSegmentDelta(segment, indexData, decoder.options); err != nil {
+		return
+	}
+
+	for _, address := range delta.AllocatedAddresses {
+		blockIndex, _ := delta.LookupBlockIndex(address)
+		blocksSize += int64(blockIndex.BlockSize)
+	}
+
+	blocksOffset = decoder.offset
+
+	if blocksData, err = decoder.read(blocksSize, block.SectionBlocks); err != nil {
+		return
+	}
+
+	if footerData, err = decoder.read(journalFooterSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if _, err = decodeJournalFooter(decoder.offset-journalFooterSize, footerData); err != nil {
+		return
+	}
+
+	isIntact = true
+
+	if err = decoder.envelope.mergeSegmentDelta(segment, delta, decoder.options); err != nil {
+		return
+	}
+
+	for _, address := range delta.AllocatedAddresses {
+		blockIndex, _ := delta.LookupBlockIndex(address)
+
+		decoder.frames = append(decoder.frames, trailerFrame{offset: blocksOffset, data: blocksData[:blockIndex.BlockSize]})
+		blocksOffset += int64(blockIndex.BlockSize)
+		blocksData = blocksData[blockIndex.BlockSize:]
+	}
+
+	decoder.blocksPending = len(delta.AllocatedAddresses)
+	decoder.segments++
+
+	return
+}
+
+// nextBlockData returns the offset, address and payload of the following block.
+func (decoder *Decoder) nextBlockData() (blockOffset int64, address block.BlockAddress, blockData []byte, err error) {
+	var (
+		addressData []byte
+		isFramed    bool = len(decoder.frames) > 0
+	)
+
+	if isFramed {
+		frame := decoder.frames[0]
+		decoder.frames = decoder.frames[1:]
+
+		blockOffset = frame.offset
+		addressData = frame.data
+	} else {
+		blockOffset = decoder.offset
+
+		if addressData, err = decoder.read(int64(decoder.envelope.Header.AddressBytes), block.SectionBlocks); err != nil {
+			return
+		}
+	}
+
+	if address, err = block.DecodeBlockAddress(addressData, decoder.envelope.Header.AddressBytes); err != nil {
+		err = &block.DecodeError{
+			Offset:  blockOffset,
+			Section: block.SectionBlocks,
+			Err:     err,
+		}
+		return
+	}
+
+	blockIndex, hasBlockIndex := decoder.envelope.Index.LookupBlockIndex(address)
+
+	if !hasBlockIndex {
+		err = &block.DecodeError{
+			Offset:  blockOffset,
+			Section: block.SectionBlocks,
+			Address: address,
+			Err:     block.ErrUnknownAddress,
+		}
+		return
+	}
+
+	if isFramed && int64(blockIndex.BlockSize) != int64(len(addressData)) {
+		err = &block.DecodeError{
+			Offset:    blockOffset,
+			Section:   block.SectionBlocks,
+			Address:   address,
+			BlockType: blockIndex.Type,
+			Key:       blockIndex.Key,
+			Err:       fmt.Errorf("%w: block of %d bytes is indexed with %d bytes", block.ErrInvalidSize, len(addressData), blockIndex.BlockSize),
+		}
+		return
+	}
+
+	if int64(blockIndex.BlockSize) < int64(decoder.envelope.Header.AddressBytes) {
+		err = &block.DecodeError{
+			Offset:    blockOffset,
+			Section:   block.SectionBlocks,
+			Address:   address,
+			BlockType: blockIndex.Type,
+			Key:       blockIndex.Key,
+			Err:       fmt.Errorf("%w: block size %d is smaller than address", block.ErrInvalidSize, blockIndex.BlockSize),
+		}
+		return
+	}
+
+	if isFramed {
+		blockData = addressData[decoder.envelope.Header.AddressBytes:]
+		return
+	}
+
+	blockData, err = decoder.read(int64(blockIndex.BlockSize)-int64(decoder.envelope.Header.AddressBytes), block.SectionBlocks)
+	return
+}
+
+// Next decodes the following block, it returns io.EOF once every block of the index was read.
+func (decoder *Decoder) Next() (decodedBlock block.Block, err error) {
+	var (
+		blockOffset int64
+		address     block.BlockAddress
+		blockData   []byte
+	)
+
+	if err = decoder.DecodeIndex(); err != nil {
+		return
+	}
+
+	for decoder.blocksPending == 0 {
+		if !decoder.envelope.Header.HasJournal {
+			err = io.EOF
+			return
+		}
+
+		if err = decoder.nextSegment(); err != nil {
+			return
+		}
+	}
+
+	if blockOffset, address, blockData, err = decoder.nextBlockData(); err != nil {
+		return
+	}
+
+	blockIndex, _ := decoder.envelope.Index.LookupBlockIndex(address)
+
+	if decodedBlock, err = decoder.envelope.decodeVersionBlock(address, blockIndex.Type, blockData); err != nil {
+		err = &block.DecodeError{
+			Offset:    blockOffset,
+			Section:   block.SectionBlocks,
+			Address:   address,
+			BlockType: blockIndex.Type,
+			Key:       blockIndex.Key,
+			Err:       err,
+		}
+		return
+	}
+
+	decoder.blocksPending--
+
+	return
+}
+
+// expectEnd verifies that the reader holds no data after the last block. Data following the
+// last intact journal segment is skipped, as is a segment appended by an interrupted first
+// commit, which sets the journal flag only once the segment is stored.
+func (decoder *Decoder) expectEnd() (err error) {
+	var (
+		data []byte = make([]byte, len(segmentSignature))
+		size int
+	)
+
+	if decoder.isInterrupted {
+		return
+	}
+
+	if size, err = io.ReadFull(decoder.reader, data); err == io.EOF {
+		err = nil
+		return
+	}
+
+	if !decoder.envelope.Header.HasJournal && strings.HasPrefix(segmentSignature, string(data[:size])) {
+		err = nil
+		return
+	}
+
+	if err == nil || err == io.ErrUnexpectedEOF {
+		err = &block.DecodeError{
+			Offset:  decoder.offset,
+			Section: block.SectionBlocks,
+			Err:     fmt.Errorf("%w: unexpected data after last block", block.ErrInvalidSize),
+		}
+	}
+
+	return
+}
+
+// ForEach calls handler for every remaining block, stopping at the first error.
+func (decoder *Decoder) ForEach(handler func(block.Block, *index.BlockIndex) error) (err error) {
+	var decodedBlock block.Block
+
+	for {
+		if decodedBlock, err = decoder.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			return
+		}
+
+		blockIndex, _ := decoder.envelope.Index.LookupBlockIndex(decodedBlock.Address())
+
+		if err = handler(decodedBlock, blockIndex); err != nil {
+			return
+		}
+	}
+}
@@ -56,6 +56,10 @@ func (emptyBlock *EmptyBlock) SetKey(key interface{}) error {
 	return emptyBlock.envelope.Index.SetKey(emptyBlock.address, key)
 }
 
+func (emptyBlock *EmptyBlock) Parents() []block.Block {
+	return emptyBlock.envelope.lookupParents(emptyBlock.address)
+}
+
 func (emptyBlock *EmptyBlock) IsRequest() bool {
 	return emptyBlock.envelope.Index.HasFlag(emptyBlock.address, index.BitmaskRequest)
 }
@@ -84,6 +88,10 @@ func (emptyBlock *EmptyBlock) SetIsResponse(isResponse bool) block.Block {
 	return emptyBlock
 }
 
+func (emptyBlock *EmptyBlock) Interface() (interface{}, error) {
+	return nil, nil
+}
+
 func (emptyBlock *EmptyBlock) Encode(writer io.Writer) (n int, err error) {
 	var addressData []byte
 
@@ -0,0 +1,317 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+	"github.com/deitas/apo/index"
+)
+
+type EncodeOptions struct {
+	// SpillDir is the directory of the temporary file holding the blocks
+	// when the writer cannot seek, os.TempDir() is used when empty.
+	SpillDir string
+}
+
+type encodeEntry struct {
+	blockIndex *index.BlockIndex
+	block      block.Block
+}
+
+// EncodeStream writes the envelope without buffering its blocks in memory. Blocks are
+// written straight to the writer when it can seek, the header and the index are written
+// afterwards into the space left for them. Otherwise blocks are spilled into a temporary file.
+//
+// A writer which can seek must write at its offset, files opened with os.O_APPEND
+// do not and are reported with an error, use Encode for them.
+func (envelope *Envelope) EncodeStream(writer io.Writer, options ...EncodeOptions) (err error) {
+	var (
+		encodeOptions EncodeOptions
+		start         int64
+		seeker        io.WriteSeeker
+		isSeeker      bool
+	)
+
+	if len(options) > 0 {
+		encodeOptions = options[0]
+	}
+
+	if seeker, isSeeker = writer.(io.WriteSeeker); isSeeker {
+		if start, err = seeker.Seek(0, io.SeekCurrent); err == nil {
+			return envelope.encodeSeeker(seeker, start)
+		}
+	}
+
+	return envelope.encodeSpill(writer, encodeOptions.SpillDir)
+}
+
+func (envelope *Envelope) encodeEntries() (entries []encodeEntry, indexSize int64, err error) {
+	var blockIndexBuffer []byte
+
+	for _, address := range envelope.Index.AllocatedAddresses {
+		var (
+			hasBlockIndex bool
+			entry         encodeEntry
+		)
+
+		if entry.blockIndex, hasBlockIndex = envelope.Index.LookupBlockIndex(address); !hasBlockIndex {
+			continue
+		}
+
+		// every allocated address is encoded, so decoders can rely on addresses without gaps
+		if entry.block, err = envelope.allocatedBlock(address); err != nil {
+			return
+		}
+
+		// the size of a block index does not depend on the block size, so it is known before encoding blocks
+		if blockIndexBuffer, err = entry.blockIndex.ToBytes(envelope.Header); err != nil {
+			return
+		}
+
+		indexSize += int64(len(blockIndexBuffer))
+		entries = append(entries, entry)
+	}
+
+	if indexSize >= 4294967296 {
+		err = fmt.Errorf("APO index exceeded maximum size of 4 GiB")
+		return
+	}
+
+	return
+}
+
+func (envelope *Envelope) encodeBlocks(writer io.Writer, entries []encodeEntry) (checksum header.Checksum, err error) {
+	var (
+		checksumHash hash.Hash64 = header.NewChecksumHash()
+		blocksWriter io.Writer   = io.MultiWriter(writer, checksumHash)
+		blockSize    int
+	)
+
+	for _, entry := range entries {
+		if blockSize, err = entry.block.Encode(blocksWriter); err != nil {
+			return
+		}
+
+		if int64(blockSize) >= 4294967296 {
+			err = fmt.Errorf("block exceeded maximum size of 4 GiB")
+			return
+		}
+
+		entry.blockIndex.BlockSize = uint32(blockSize)
+	}
+
+	checksum = header.ChecksumFromHash(checksumHash)
+	return
+}
+
+// encodeIndex writes the index of entries, its checksum also covers metadataData,
+// the metadata section preceding the index, which is not written.
+func (envelope *Envelope) encodeIndex(writer io.Writer, entries []encodeEntry, metadataData []byte) (checksum header.Checksum, err error) {
+	var (
+		checksumHash     hash.Hash64 = header.NewChecksumHash()
+		indexWriter      io.Writer   = io.MultiWriter(writer, checksumHash)
+		blockIndexBuffer []byte
+	)
+
+	checksumHash.Write(metadataData)
+
+	for _, entry := range entries {
+		if blockIndexBuffer, err = entry.blockIndex.ToBytes(envelope.Header); err != nil {
+			return
+		}
+
+		if _, err = indexWriter.Write(blockIndexBuffer); err != nil {
+			return
+		}
+	}
+
+	checksum = header.ChecksumFromHash(checksumHash)
+	return
+}
+
+func (envelope *Envelope) encodeHeader(writer io.Writer, metadataData []byte, indexSize int64) (err error) {
+	var (
+		indexSizeBuffer []byte = make([]byte, 4)
+		encodedHeader   header.Header
+	)
+
+	// blocks are always encoded in the current format, Header.Encode stamps its version
+	encodedHeader = *envelope.Header
+
+	// the layout of decoded data is not kept, envelopes are always encoded with a single index
+	encodedHeader.HasTrailerIndex = false
+	encodedHeader.HasJournal = false
+	encodedHeader.HasMetadata = envelope.Metadata != nil
+
+	if _, err = encodedHeader.Encode(writer); err != nil {
+		return
+	}
+
+	envelope.Header.Version = header.CurrentVersion
+
+	if _, err = writer.Write(metadataData); err != nil {
+		return
+	}
+
+	binary.LittleEndian.PutUint32(indexSizeBuffer, uint32(indexSize))
+
+	_, err = writer.Write(indexSizeBuffer)
+	return
+}
+
+// encodeMetadata returns the metadata section following the header, nil without metadata.
+func (envelope *Envelope) encodeMetadata() (data []byte, err error) {
+	var buffer bytes.Buffer
+
+	if envelope.Metadata == nil {
+		return
+	}
+
+	if _, err = envelope.Metadata.Encode(&buffer); err != nil {
+		return
+	}
+
+	data = buffer.Bytes()
+	return
+}
+
+func (envelope *Envelope) encodeSeeker(writer io.WriteSeeker, start int64) (err error) {
+	var (
+		entries      []encodeEntry
+		indexSize    int64
+		metadataData []byte
+		indexOffset  int64
+		end          int64
+	)
+
+	if entries, indexSize, err = envelope.encodeEntries(); err != nil {
+		return
+	}
+
+	if metadataData, err = envelope.encodeMetadata(); err != nil {
+		return
+	}
+
+	indexOffset = 30 + int64(len(metadataData))
+
+	// reserve space for the header, the metadata, the index size and the index
+	if _, err = io.CopyN(writer, zeroReader{}, indexOffset+indexSize); err != nil {
+		return
+	}
+
+	if err = expectOffset(writer, start+indexOffset+indexSize); err != nil {
+		return
+	}
+
+	if envelope.Header.BlocksChecksum, err = envelope.encodeBlocks(writer, entries); err != nil {
+		return
+	}
+
+	if end, err = writer.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+
+	if _, err = writer.Seek(start+indexOffset, io.SeekStart); err != nil {
+		return
+	}
+
+	if envelope.Header.IndexChecksum, err = envelope.encodeIndex(writer, entries, metadataData); err != nil {
+		return
+	}
+
+	if err = expectOffset(writer, start+indexOffset+indexSize); err != nil {
+		return
+	}
+
+	if _, err = writer.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+
+	if err = envelope.encodeHeader(writer, metadataData, indexSize); err != nil {
+		return
+	}
+
+	_, err = writer.Seek(end, io.SeekStart)
+	return
+}
+
+func (envelope *Envelope) encodeSpill(writer io.Writer, spillDir string) (err error) {
+	var (
+		entries      []encodeEntry
+		indexSize    int64
+		metadataData []byte
+		spillFile    *os.File
+	)
+
+	if entries, indexSize, err = envelope.encodeEntries(); err != nil {
+		return
+	}
+
+	if metadataData, err = envelope.encodeMetadata(); err != nil {
+		return
+	}
+
+	if spillFile, err = ioutil.TempFile(spillDir, "apo-spill-"); err != nil {
+		return
+	}
+
+	defer os.Remove(spillFile.Name())
+	defer spillFile.Close()
+
+	if envelope.Header.BlocksChecksum, err = envelope.encodeBlocks(spillFile, entries); err != nil {
+		return
+	}
+
+	// the index checksum is written before the index, so the index is encoded twice instead of buffered
+	if envelope.Header.IndexChecksum, err = envelope.encodeIndex(ioutil.Discard, entries, metadataData); err != nil {
+		return
+	}
+
+	if err = envelope.encodeHeader(writer, metadataData, indexSize); err != nil {
+		return
+	}
+
+	if _, err = envelope.encodeIndex(writer, entries, nil); err != nil {
+		return
+	}
+
+	if _, err = spillFile.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	_, err = io.Copy(writer, spillFile)
+	return
+}
+
+// expectOffset verifies that data was written at the offset of the writer, which
+// writers appending every write to the end of a file do not.
+func expectOffset(writer io.WriteSeeker, expected int64) (err error) {
+	var offset int64
+
+	if offset, err = writer.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+
+	if offset != expected {
+		err = fmt.Errorf("writer is at offset %d instead of %d, it does not write at its offset", offset, expected)
+	}
+
+	return
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(data []byte) (int, error) {
+	for cursor := range data {
+		data[cursor] = 0x0
+	}
+
+	return len(data), nil
+}
@@ -0,0 +1,113 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deitas/apo/block"
+)
+
+func TestEncodeStream(t *testing.T) {
+	var input map[string]interface{} = map[string]interface{}{
+		"string": "value",
+		"int":    -42,
+		"float":  1.5,
+		"list":   []interface{}{true, nil, map[string]interface{}{"nested": "object"}},
+	}
+
+	tests := []struct {
+		name   string
+		prefix []byte
+		encode func(t *testing.T, envelope *Envelope, prefix []byte) []byte
+	}{
+		{"spill", nil, func(t *testing.T, envelope *Envelope, prefix []byte) []byte {
+			var buffer *bytes.Buffer = bytes.NewBuffer(prefix)
+
+			if err := envelope.EncodeStream(buffer, EncodeOptions{SpillDir: t.TempDir()}); err != nil {
+				t.Fatal(err)
+			}
+
+			return buffer.Bytes()
+		}},
+		{"seeker", nil, encodeFile},
+		{"seeker after data", []byte("prefix"), encodeFile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				envelope *Envelope = parseEnvelope(t, input)
+				expected []byte    = marshalEnvelope(t, envelope)
+				data     []byte    = test.encode(t, envelope, test.prefix)
+				decoded  *Envelope = NewEnvelope()
+			)
+
+			if !bytes.HasPrefix(data, test.prefix) || !bytes.Equal(data[len(test.prefix):], expected) {
+				t.Fatalf("expected the encoding of Encode after %q, got %x", test.prefix, data)
+			}
+
+			if err := decoded.Decode(bytes.NewReader(data[len(test.prefix):])); err != nil {
+				t.Fatal(err)
+			}
+
+			if actual, expectedJSON := encodeJSONString(t, decoded), encodeJSONString(t, envelope); actual != expectedJSON {
+				t.Fatalf("expected %s, got %s", expectedJSON, actual)
+			}
+		})
+	}
+}
+
+func encodeFile(t *testing.T, envelope *Envelope, prefix []byte) []byte {
+	file, err := os.Create(filepath.Join(t.TempDir(), "stream.apo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = envelope.EncodeStream(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestEncodeMissingBlock(t *testing.T) {
+	var envelope *Envelope = parseEnvelope(t, map[string]interface{}{"a": "b", "c": "d"})
+
+	delete(envelope.Blocks, envelope.Index.AllocatedAddresses[0])
+
+	tests := []struct {
+		name   string
+		encode func() error
+	}{
+		{"encode", func() error { return envelope.Encode(&bytes.Buffer{}) }},
+		{"spill", func() error { return envelope.EncodeStream(&bytes.Buffer{}, EncodeOptions{SpillDir: t.TempDir()}) }},
+		{"trailer", func() error { return envelope.EncodeTrailer(&bytes.Buffer{}) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.encode(); !errors.Is(err, block.ErrUnknownAddress) {
+				t.Fatalf("expected %v, got %v", block.ErrUnknownAddress, err)
+			}
+		})
+	}
+}
@@ -2,12 +2,13 @@ package envelope
 
 import (
 	"bytes"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/deitas/apo/block"
@@ -18,12 +19,94 @@ import (
 type Options struct {
 	IsExtension              bool
 	EnableMemoryOptimization bool
+
+	// Limits applied by ParseBlock to untrusted input, zero values disable a limit.
+	// MaxPayload bounds the total bytes of parsed strings and object keys.
+	MaxDepth   int
+	MaxBlocks  int
+	MaxPayload int64
+}
+
+// ParseError reports a failure of ParseBlock together with the key path of the offending value.
+type ParseError struct {
+	Path []interface{}
+	Err  error
+}
+
+func (parseError *ParseError) Error() string {
+	return fmt.Sprintf("parse %s: %s", FormatPath(parseError.Path), parseError.Err)
+}
+
+func (parseError *ParseError) Unwrap() error {
+	return parseError.Err
+}
+
+// FormatPath formats a key path as used by Walk and ParseError, e.g. users[3].name
+func FormatPath(path []interface{}) string {
+	var builder strings.Builder
+
+	if len(path) == 0 {
+		return "(root)"
+	}
+
+	for _, key := range path {
+		switch typedKey := key.(type) {
+		case int:
+			fmt.Fprintf(&builder, "[%d]", typedKey)
+		default:
+			if builder.Len() > 0 {
+				builder.WriteString(".")
+			}
+
+			fmt.Fprint(&builder, typedKey)
+		}
+	}
+
+	return builder.String()
+}
+
+// DecodeOptions bound the resources used by Decode when reading untrusted data,
+// zero values disable a limit.
+type DecodeOptions struct {
+	MaxSize      int64
+	MaxBlocks    int
+	MaxBlockSize uint32
+	MaxKeyLength int
+	MaxDepth     int
+	// MaxExpandedBlocks bounds the blocks expanded by Interface, Walk, EncodeJSON and
+	// ApplyExtensions, which visit a block once per reference, so a few objects sharing
+	// children cannot expand to an exponential tree. Decode checks the whole envelope.
+	MaxExpandedBlocks int
+}
+
+func (options DecodeOptions) indexLimits() index.Limits {
+	return index.Limits{
+		MaxBlocks:    options.MaxBlocks,
+		MaxBlockSize: options.MaxBlockSize,
+		MaxKeyLength: options.MaxKeyLength,
+	}
 }
 
 type Envelope struct {
 	Header *header.Header
-	Index  *index.Index
-	Blocks block.Blocks
+	// Metadata is encoded in a section following the header when set.
+	Metadata *header.Metadata
+	Index    *index.Index
+	Blocks   block.Blocks
+	parents  map[block.BlockAddress][]block.BlockAddress
+
+	options      Options
+	parsePath    []interface{}
+	parsePayload int64
+	loadBlock    func(block.BlockAddress) (block.Block, error)
+
+	// limits decoded envelopes were read with, applied to traversals of their blocks
+	decodeOptions DecodeOptions
+
+	// pointers dereferenced by ParseBlock count as nesting levels
+	parsePointers map[parsedPointer]bool
+
+	versionDecoder BlockDecoder
 }
 
 func (envelope Envelope) allocateBlock(block block.Block) (err error) {
@@ -33,25 +116,86 @@ func (envelope Envelope) allocateBlock(block block.Block) (err error) {
 
 	envelope.Blocks.Set(address, block)
 
+	var addressBytes int
+
 	blocksCount := len(envelope.Index.AllocatedAddresses)
 	if blocksCount < 256 { // 2^8
-		envelope.Header.AddressBytes = 1
+		addressBytes = 1
 	} else if blocksCount < 65536 { // 2^16
-		envelope.Header.AddressBytes = 2
+		addressBytes = 2
 	} else if blocksCount < 16777216 { // 2^24
-		envelope.Header.AddressBytes = 3
+		addressBytes = 3
 	} else if blocksCount < 4294967296 { // 2^32
-		envelope.Header.AddressBytes = 4
+		addressBytes = 4
 	} else if blocksCount < 1099511627776 { // 2^40
-		envelope.Header.AddressBytes = 5
+		addressBytes = 5
 	} else if blocksCount < 281474976710656 { // 2^48
-		envelope.Header.AddressBytes = 6
+		addressBytes = 6
 	} else if blocksCount < 72057594037927936 { // 2^54
-		envelope.Header.AddressBytes = 7
+		addressBytes = 7
 	} else if blocksCount <= 9223372036854775807 { // 2^63 - 1
-		envelope.Header.AddressBytes = 8
+		addressBytes = 8
 	} else {
 		err = fmt.Errorf("maximum address size exceeded")
+		return
+	}
+
+	// address bytes only grow, so an envelope can be prepared for a known number of blocks
+	if addressBytes > envelope.Header.AddressBytes {
+		envelope.Header.AddressBytes = addressBytes
+	}
+
+	return
+}
+
+func (envelope *Envelope) linkParent(address block.BlockAddress, parentAddress block.BlockAddress) {
+	if envelope.parents == nil {
+		envelope.parents = map[block.BlockAddress][]block.BlockAddress{}
+	}
+
+	for _, linkedAddress := range envelope.parents[address] {
+		if linkedAddress == parentAddress {
+			return
+		}
+	}
+
+	envelope.parents[address] = append(envelope.parents[address], parentAddress)
+}
+
+// releaseBlocks removes the blocks allocated after the first count addresses,
+// so a construction failing halfway leaves no blocks without parent behind.
+func (envelope *Envelope) releaseBlocks(count int) {
+	if count >= len(envelope.Index.AllocatedAddresses) {
+		return
+	}
+
+	for _, address := range envelope.Index.AllocatedAddresses[count:] {
+		delete(envelope.Index.Blocks, address)
+		delete(envelope.Blocks, address)
+		delete(envelope.parents, address)
+	}
+
+	envelope.Index.AllocatedAddresses = envelope.Index.AllocatedAddresses[:count]
+
+	// released object blocks may have been linked as parent of blocks allocated before
+	for address, parentAddresses := range envelope.parents {
+		var linkedAddresses []block.BlockAddress
+
+		for _, parentAddress := range parentAddresses {
+			if int(parentAddress) <= count {
+				linkedAddresses = append(linkedAddresses, parentAddress)
+			}
+		}
+
+		envelope.parents[address] = linkedAddresses
+	}
+}
+
+func (envelope *Envelope) lookupParents(address block.BlockAddress) (parents []block.Block) {
+	for _, parentAddress := range envelope.parents[address] {
+		if parent, hasParent := envelope.LookupBlock(parentAddress); hasParent {
+			parents = append(parents, parent)
+		}
 	}
 
 	return
@@ -59,12 +203,14 @@ func (envelope Envelope) allocateBlock(block block.Block) (err error) {
 
 func NewEnvelope(options ...Options) (envelope *Envelope) {
 	envelope = &Envelope{
-		Header: header.NewHeader(),
-		Index:  index.NewIndex(),
-		Blocks: block.Blocks{},
+		Header:  header.NewHeader(),
+		Index:   index.NewIndex(),
+		Blocks:  block.Blocks{},
+		parents: map[block.BlockAddress][]block.BlockAddress{},
 	}
 
 	if len(options) > 0 {
+		envelope.options = options[0]
 		envelope.Header.IsExtension = options[0].IsExtension
 		envelope.Header.EnableMemoryOptimization = options[0].EnableMemoryOptimization
 	}
@@ -72,7 +218,57 @@ func NewEnvelope(options ...Options) (envelope *Envelope) {
 	return
 }
 
+func (envelope *Envelope) parseError(err error) error {
+	return &ParseError{
+		Path: append([]interface{}{}, envelope.parsePath...),
+		Err:  err,
+	}
+}
+
+func (envelope *Envelope) addParsePayload(size int) (err error) {
+	envelope.parsePayload += int64(size)
+
+	if envelope.options.MaxPayload > 0 && envelope.parsePayload > envelope.options.MaxPayload {
+		err = envelope.parseError(fmt.Errorf("%w: payload is larger than %d bytes", block.ErrLimitExceeded, envelope.options.MaxPayload))
+	}
+
+	return
+}
+
+// parseItem parses a value of an object or array and sets its key,
+// keeping track of the key path for limits and errors.
+func (envelope *Envelope) parseItem(key interface{}, input interface{}) (itemBlock block.Block, err error) {
+	if stringKey, isString := key.(string); isString {
+		if err = envelope.addParsePayload(len(stringKey)); err != nil {
+			return
+		}
+	}
+
+	envelope.parsePath = append(envelope.parsePath, key)
+	defer func() {
+		envelope.parsePath = envelope.parsePath[:len(envelope.parsePath)-1]
+	}()
+
+	if itemBlock, err = envelope.ParseBlock(input); err != nil {
+		return
+	}
+
+	err = itemBlock.SetKey(key)
+
+	return
+}
+
 func (envelope *Envelope) ParseBlock(input interface{}) (parsedBlock block.Block, err error) {
+	if envelope.options.MaxDepth > 0 && len(envelope.parsePath)+len(envelope.parsePointers) > envelope.options.MaxDepth {
+		err = envelope.parseError(fmt.Errorf("%w: nested deeper than %d levels", block.ErrLimitExceeded, envelope.options.MaxDepth))
+		return
+	}
+
+	if envelope.options.MaxBlocks > 0 && len(envelope.Index.AllocatedAddresses) >= envelope.options.MaxBlocks {
+		err = envelope.parseError(fmt.Errorf("%w: more than %d blocks", block.ErrLimitExceeded, envelope.options.MaxBlocks))
+		return
+	}
+
 	switch value := input.(type) {
 	case nil:
 		parsedBlock, err = envelope.parseNil()
@@ -106,7 +302,7 @@ func (envelope *Envelope) ParseBlock(input interface{}) (parsedBlock block.Block
 		case reflect.Ptr:
 			parsedBlock, err = envelope.parsePointer(value)
 		default:
-			err = fmt.Errorf("unknown type of %+v", reflectValue.Kind())
+			err = envelope.parseError(fmt.Errorf("unknown type of %+v", reflectValue.Kind()))
 		}
 	}
 
@@ -127,7 +323,11 @@ func (envelope *Envelope) TraverseBlocks(condition func(block.Block, *index.Bloc
 			continue
 		}
 
-		block := envelope.Blocks.Get(blockAddress)
+		block, hasBlock := envelope.LookupBlock(blockAddress)
+		if !hasBlock {
+			continue
+		}
+
 		if condition(block, blockIndex) {
 			handler(block, blockIndex)
 		}
@@ -153,7 +353,7 @@ func (envelope *Envelope) TraverseObjects(handler TraverseHandler) {
 }
 
 func (envelope *Envelope) TraverseBinaries(handler TraverseHandler) {
-	envelope.TraverseBlockType(block.Object, func(block block.Block, blockIndex *index.BlockIndex) {
+	envelope.TraverseBlockType(block.Binary, func(block block.Block, blockIndex *index.BlockIndex) {
 		handler(block.(*BinaryBlock), blockIndex)
 	})
 }
@@ -170,80 +370,189 @@ func (envelope *Envelope) TraverseResponses(handler TraverseHandler) {
 	}, handler)
 }
 
-func (envelope *Envelope) Encode(writer io.Writer) (err error) {
+type WalkHandler = func(path []interface{}, depth int, block block.Block, blockIndex *index.BlockIndex) error
+
+var (
+	// SkipChildren is returned by a WalkHandler to skip the children of the current object block.
+	SkipChildren = errors.New("skip children")
+	// StopWalk is returned by a WalkHandler to end the walk without reporting an error.
+	StopWalk = errors.New("stop walk")
+)
+
+// LookupBlock returns the block with address, loading it first when the envelope is read lazily.
+// Blocks which fail to load are reported as missing, Reader.Block returns their error.
+func (envelope *Envelope) LookupBlock(address block.BlockAddress) (block block.Block, hasBlock bool) {
+	block, hasBlock, _ = envelope.lookupBlock(address)
+	return
+}
+
+// lookupBlock returns the error of loading the block, missing blocks are no error.
+func (envelope *Envelope) lookupBlock(address block.BlockAddress) (block block.Block, hasBlock bool, err error) {
+	if block, hasBlock = envelope.Blocks.Lookup(address); hasBlock || envelope.loadBlock == nil {
+		return
+	}
+
+	if block, err = envelope.loadBlock(address); err != nil {
+		return nil, false, err
+	}
+
+	return block, true, nil
+}
+
+// allocatedBlock returns the block with address like lookupBlock, reporting a missing block as error.
+func (envelope *Envelope) allocatedBlock(address block.BlockAddress) (allocated block.Block, err error) {
+	var hasBlock bool
+
+	if allocated, hasBlock, err = envelope.lookupBlock(address); err == nil && !hasBlock {
+		err = fmt.Errorf("%w: block %d is allocated but missing", block.ErrUnknownAddress, address)
+	}
+
+	return
+}
+
+// Root returns the last allocated block which is not a child of any object block.
+// Blocks which fail to load are skipped, LoadRoot returns their error.
+func (envelope *Envelope) Root() block.Block {
+	root, _ := envelope.LoadRoot()
+	return root
+}
+
+// LoadRoot returns the root like Root, reporting the error of a block which fails to load.
+// Envelopes read lazily link parents once object blocks are loaded, so their object blocks are loaded first.
+func (envelope *Envelope) LoadRoot() (root block.Block, err error) {
 	var (
-		indexBufferSize       int
-		indexBufferSizeBuffer []byte
-		indexBuffer           *bytes.Buffer = &bytes.Buffer{}
-		blocksBuffer          *bytes.Buffer = &bytes.Buffer{}
+		hasRoot bool
+		loadErr error
 	)
 
-	for allocatedAddress := range envelope.Index.AllocatedAddresses {
-		var (
-			blockAddress     block.BlockAddress = block.BlockAddress(allocatedAddress)
-			hasBlockIndex    bool
-			blockIndex       *index.BlockIndex
-			blockIndexBuffer []byte
-			hasBlock         bool
-			block            block.Block
-			blockSize        int
-		)
+	if envelope.loadBlock != nil {
+		for _, address := range envelope.Index.AllocatedAddresses {
+			if blockIndex, hasBlockIndex := envelope.Index.LookupBlockIndex(address); hasBlockIndex && blockIndex.Type == block.Object {
+				if _, _, err = envelope.lookupBlock(address); err != nil && loadErr == nil {
+					loadErr = err
+				}
+			}
+		}
+	}
 
-		if blockIndex, hasBlockIndex = envelope.Index.LookupBlockIndex(blockAddress); !hasBlockIndex {
-			continue
+	defer func() {
+		if err == nil {
+			err = loadErr
 		}
+	}()
+
+	for cursor := len(envelope.Index.AllocatedAddresses) - 1; cursor >= 0; cursor-- {
+		var address block.BlockAddress = envelope.Index.AllocatedAddresses[cursor]
 
-		if block, hasBlock = envelope.Blocks[blockAddress]; !hasBlock {
+		if len(envelope.parents[address]) > 0 {
 			continue
 		}
 
-		if blockSize, err = block.Encode(blocksBuffer); err != nil {
+		if root, hasRoot, err = envelope.lookupBlock(address); err != nil || hasRoot {
 			return
 		}
+	}
 
-		if blockSize >= 4294967296 {
-			err = fmt.Errorf("block exceeded maximum size of 4 GiB")
-			return
-		}
+	return
+}
 
-		blockIndex.BlockSize = uint32(blockSize)
-		if blockIndexBuffer, err = blockIndex.ToBytes(envelope.Header); err != nil {
-			return
+// Walk traverses the tree below root depth-first, calling handler with the key path
+// leading to every block. Children of an object block are visited in the order of its values.
+func (envelope *Envelope) Walk(root block.Block, handler WalkHandler) (err error) {
+	if root == nil {
+		return
+	}
+
+	if err = envelope.walk(root, []interface{}{}, newTraversal(envelope), handler); err == StopWalk {
+		err = nil
+	}
+
+	return
+}
+
+func (envelope *Envelope) walk(current block.Block, path []interface{}, traversal *traversal, handler WalkHandler) (err error) {
+	var (
+		blockIndex  *index.BlockIndex
+		objectBlock *ObjectBlock
+		isObject    bool
+		children    []Child
+	)
+
+	if err = traversal.expand(); err != nil {
+		return
+	}
+
+	blockIndex, _ = envelope.Index.LookupBlockIndex(current.Address())
+
+	if err = handler(path, len(path), current, blockIndex); err != nil {
+		if err == SkipChildren {
+			err = nil
 		}
 
-		if _, err = indexBuffer.Write(blockIndexBuffer); err != nil {
+		return
+	}
+
+	if objectBlock, isObject = current.(*ObjectBlock); !isObject {
+		return
+	}
+
+	if children, err = objectBlock.LoadChildren(); err != nil {
+		return
+	}
+
+	if err = traversal.enter(objectBlock); err != nil {
+		return
+	}
+
+	defer traversal.leave(objectBlock)
+
+	for _, child := range children {
+		childPath := make([]interface{}, len(path), len(path)+1)
+		copy(childPath, path)
+
+		if err = envelope.walk(child.Block, append(childPath, child.Key), traversal, handler); err != nil {
 			return
 		}
 	}
 
-	indexBufferSize = indexBuffer.Len()
-	if indexBufferSize >= 4294967296 {
-		err = fmt.Errorf("APO index exceeded maximum size of 4 GiB")
+	return
+}
+
+// Encode writes the envelope sequentially, buffering its blocks in memory.
+// EncodeStream writes large envelopes without buffering them.
+func (envelope *Envelope) Encode(writer io.Writer) (err error) {
+	var (
+		entries      []encodeEntry
+		indexSize    int64
+		metadataData []byte
+		blocksBuffer *bytes.Buffer = &bytes.Buffer{}
+	)
+
+	if entries, indexSize, err = envelope.encodeEntries(); err != nil {
 		return
 	}
 
-	indexBufferSizeBuffer = make([]byte, 4)
-	binary.LittleEndian.PutUint32(indexBufferSizeBuffer, uint32(indexBufferSize))
-
-	envelope.Header.IndexChecksum = header.CalcuateChecksum(indexBuffer)
-	envelope.Header.BlocksChecksum = header.CalcuateChecksum(blocksBuffer)
+	if metadataData, err = envelope.encodeMetadata(); err != nil {
+		return
+	}
 
-	if _, err = envelope.Header.Encode(writer); err != nil {
+	if envelope.Header.BlocksChecksum, err = envelope.encodeBlocks(blocksBuffer, entries); err != nil {
 		return
 	}
 
-	if _, err = writer.Write(indexBufferSizeBuffer); err != nil {
+	if envelope.Header.IndexChecksum, err = envelope.encodeIndex(ioutil.Discard, entries, metadataData); err != nil {
 		return
 	}
 
-	if _, err = indexBuffer.WriteTo(writer); err != nil {
+	if err = envelope.encodeHeader(writer, metadataData, indexSize); err != nil {
 		return
 	}
 
-	if _, err = blocksBuffer.WriteTo(writer); err != nil {
+	if _, err = envelope.encodeIndex(writer, entries, nil); err != nil {
 		return
 	}
 
+	_, err = blocksBuffer.WriteTo(writer)
 	return
 }
 
@@ -258,107 +567,214 @@ func (envelope *Envelope) Marshal() (data []byte, err error) {
 	return
 }
 
-func (envelope *Envelope) Decode(reader io.Reader) (err error) {
+// Decode reads envelopes with the index before the blocks as well as with a trailer index.
+func (envelope *Envelope) Decode(reader io.Reader, options ...DecodeOptions) (err error) {
+	var (
+		decoder      *Decoder = newDecoder(envelope, reader, options...)
+		decodedBlock block.Block
+	)
+
+	for {
+		if decodedBlock, err = decoder.Next(); err == io.EOF {
+			err = decoder.expectEnd()
+			break
+		}
+
+		if err != nil {
+			return
+		}
+
+		envelope.Blocks.Set(decodedBlock.Address(), decodedBlock)
+	}
+
+	if err == nil && decoder.options.MaxDepth > 0 {
+		err = envelope.checkDepth(decoder.options.MaxDepth)
+	}
+
+	if err == nil && decoder.options.MaxExpandedBlocks > 0 {
+		err = envelope.checkExpansion(decoder.options.MaxExpandedBlocks)
+	}
+
+	envelope.decodeOptions = decoder.options
+
+	return
+}
+
+func (envelope *Envelope) decodeBlock(address block.BlockAddress, blockType block.BlockType, buffer []byte) (decodedBlock block.Block, err error) {
+	switch blockType {
+	case block.Address:
+		decodedBlock, err = envelope.decodeAddress(address, buffer)
+	case block.Empty:
+		decodedBlock, err = envelope.decodeEmpty(address, buffer)
+	case block.Object:
+		decodedBlock, err = envelope.decodeObject(address, buffer)
+	case block.Binary:
+		decodedBlock, err = envelope.decodeBinary(address, buffer)
+	case block.Boolean:
+		decodedBlock, err = envelope.decodeBoolean(address, buffer)
+	case block.String:
+		decodedBlock, err = envelope.decodeString(address, buffer)
+	case block.Int:
+		decodedBlock, err = envelope.decodeInt(address, buffer)
+	case block.Float:
+		decodedBlock, err = envelope.decodeFloat(address, buffer)
+	case block.DateTime:
+		// TODO: datetime block
+		decodedBlock, err = envelope.decodeEmpty(address, buffer)
+	default:
+		err = block.ErrInvalidType
+	}
+
+	return
+}
+
+// checkDepth verifies that no object block is nested deeper than maxDepth levels,
+// reporting cyclic references as exceeding the limit as well.
+func (envelope *Envelope) checkDepth(maxDepth int) (err error) {
+	var heights map[block.BlockAddress]int = map[block.BlockAddress]int{}
+
+	for _, address := range envelope.Index.AllocatedAddresses {
+		if len(envelope.parents[address]) > 0 {
+			continue
+		}
+
+		if _, err = envelope.measureHeight(address, 1, maxDepth, heights); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (envelope *Envelope) measureHeight(address block.BlockAddress, depth int, maxDepth int, heights map[block.BlockAddress]int) (height int, err error) {
 	var (
-		data         []byte
-		dataSize     int
-		blocksOffset uint32
-		cursor       int
+		objectBlock *ObjectBlock
+		isObject    bool
+		isMeasured  bool
+		childHeight int
 	)
 
-	if data, err = ioutil.ReadAll(reader); err != nil {
+	if objectBlock, isObject = envelope.Blocks.Get(address).(*ObjectBlock); !isObject {
 		return
 	}
 
-	dataSize = len(data)
+	if height, isMeasured = heights[address]; isMeasured {
+		if height < 0 || depth+height-1 > maxDepth {
+			err = envelope.depthError(address, maxDepth)
+		}
 
-	if err = envelope.Header.Decode(data); err != nil {
 		return
 	}
 
-	if blocksOffset, err = envelope.Index.Decode(envelope.Header, data); err != nil {
+	if depth > maxDepth {
+		err = envelope.depthError(address, maxDepth)
 		return
 	}
 
-	cursor = int(blocksOffset)
+	// a negative height marks an object block that is being measured, reaching it again means a cycle
+	heights[address] = -1
 
-	for cursor < dataSize {
-		var (
-			addressBuffer []byte
-			address       block.BlockAddress
-			hasBlockIndex bool
-			blockIndex    *index.BlockIndex
-			blockBuffer   []byte
-			decodedBlock  block.Block
-		)
+	for _, childAddress := range objectBlock.Values {
+		if childHeight, err = envelope.measureHeight(childAddress, depth+1, maxDepth, heights); err != nil {
+			return
+		}
 
-		switch envelope.Header.AddressBytes {
-		case 1:
-			addressBuffer = []byte{data[cursor], 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-		case 2:
-			addressBuffer = []byte{data[cursor], data[cursor+1]}
-			address = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-		case 3:
-			addressBuffer = []byte{data[cursor], data[cursor+1], data[cursor+2], 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-		case 4:
-			addressBuffer = []byte{data[cursor], data[cursor+1], data[cursor+2], data[cursor+3]}
-			address = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-		case 5:
-			addressBuffer = []byte{data[cursor], data[cursor+1], data[cursor+2], data[cursor+3], data[cursor+4], 0x0, 0x0, 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-		case 6:
-			addressBuffer = []byte{data[cursor], data[cursor+1], data[cursor+2], data[cursor+3], data[cursor+4], data[cursor+5], 0x0, 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-		case 7:
-			addressBuffer = []byte{data[cursor], data[cursor+1], data[cursor+2], data[cursor+3], data[cursor+4], data[cursor+5], data[cursor+6], 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-		case 8:
-			addressBuffer = []byte{data[cursor], data[cursor+1], data[cursor+2], data[cursor+3], data[cursor+4], data[cursor+5], data[cursor+6], data[cursor+7]}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
+		if childHeight > height {
+			height = childHeight
 		}
+	}
 
-		if blockIndex, hasBlockIndex = envelope.Index.LookupBlockIndex(address); !hasBlockIndex {
-			err = fmt.Errorf("block index with address %d does not exist", address)
+	height++
+	heights[address] = height
+
+	return
+}
+
+// checkExpansion verifies that expanding the trees below all root blocks, visiting shared
+// blocks once per reference, stays within maxExpanded blocks. Cyclic references exceed it.
+func (envelope *Envelope) checkExpansion(maxExpanded int) (err error) {
+	var (
+		sizes    map[block.BlockAddress]int = map[block.BlockAddress]int{}
+		size     int
+		expanded int
+	)
+
+	for _, address := range envelope.Index.AllocatedAddresses {
+		if len(envelope.parents[address]) > 0 {
+			continue
+		}
+
+		if size, err = envelope.measureExpansion(address, maxExpanded, sizes); err != nil {
 			return
 		}
 
-		blockBuffer = data[cursor+envelope.Header.AddressBytes : cursor+int(blockIndex.BlockSize)]
-
-		switch blockIndex.Type {
-		case block.Address:
-			decodedBlock, err = envelope.decodeAddress(address, blockBuffer)
-		case block.Empty:
-			decodedBlock, err = envelope.decodeEmpty(address, blockBuffer)
-		case block.Object:
-			decodedBlock, err = envelope.decodeObject(address, blockBuffer)
-		case block.Binary:
-			decodedBlock, err = envelope.decodeBinary(address, blockBuffer)
-		case block.Boolean:
-			decodedBlock, err = envelope.decodeBoolean(address, blockBuffer)
-		case block.String:
-			decodedBlock, err = envelope.decodeString(address, blockBuffer)
-		case block.Int:
-			decodedBlock, err = envelope.decodeInt(address, blockBuffer)
-		case block.Float:
-			decodedBlock, err = envelope.decodeFloat(address, blockBuffer)
-		case block.DateTime:
-			// TODO: datetime block
-			decodedBlock, err = envelope.decodeEmpty(address, blockBuffer)
-		default:
-			err = fmt.Errorf("invalid block type: %d", blockIndex.Type)
+		if expanded += size; expanded > maxExpanded {
+			return envelope.expansionError(address, maxExpanded)
+		}
+	}
+
+	return
+}
+
+func (envelope *Envelope) measureExpansion(address block.BlockAddress, maxExpanded int, sizes map[block.BlockAddress]int) (size int, err error) {
+	var (
+		objectBlock *ObjectBlock
+		isObject    bool
+		isMeasured  bool
+		childSize   int
+	)
+
+	if size, isMeasured = sizes[address]; isMeasured {
+		return
+	}
+
+	size = 1
+
+	if objectBlock, isObject = envelope.Blocks.Get(address).(*ObjectBlock); !isObject {
+		sizes[address] = size
+		return
+	}
+
+	// an object block being measured counts as exceeding the limit, so reaching it again in a cycle fails
+	sizes[address] = maxExpanded + 1
+
+	for _, childAddress := range objectBlock.Values {
+		if childSize, err = envelope.measureExpansion(childAddress, maxExpanded, sizes); err != nil {
 			return
 		}
 
-		if err != nil {
+		if size += childSize; size > maxExpanded {
+			err = envelope.expansionError(address, maxExpanded)
 			return
 		}
+	}
 
-		envelope.Blocks.Set(address, decodedBlock)
+	sizes[address] = size
 
-		cursor += int(blockIndex.BlockSize)
+	return
+}
+
+func (envelope *Envelope) expansionError(address block.BlockAddress, maxExpanded int) error {
+	var decodeError *block.DecodeError = &block.DecodeError{
+		Section: block.SectionBlocks,
+		Address: address,
+		Err:     fmt.Errorf("%w: expands to more than %d blocks", block.ErrLimitExceeded, maxExpanded),
 	}
 
-	return
+	if blockIndex, hasBlockIndex := envelope.Index.LookupBlockIndex(address); hasBlockIndex {
+		decodeError.BlockType = blockIndex.Type
+		decodeError.Key = blockIndex.Key
+	}
+
+	return decodeError
+}
+
+func (envelope *Envelope) depthError(address block.BlockAddress, maxDepth int) error {
+	return &block.DecodeError{
+		Section:   block.SectionBlocks,
+		Address:   address,
+		BlockType: block.Object,
+		Key:       envelope.Index.GetKey(address),
+		Err:       fmt.Errorf("%w: objects nested deeper than %d levels", block.ErrLimitExceeded, maxDepth),
+	}
 }
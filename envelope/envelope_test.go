@@ -0,0 +1,158 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/index"
+)
+
+func parseEnvelope(t *testing.T, input interface{}) *Envelope {
+	t.Helper()
+
+	var envelope *Envelope = NewEnvelope()
+
+	if _, err := envelope.ParseBlock(input); err != nil {
+		t.Fatalf("parse %v: %v", input, err)
+	}
+
+	return envelope
+}
+
+func marshalEnvelope(t *testing.T, envelope *Envelope) []byte {
+	t.Helper()
+
+	data, err := envelope.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	return data
+}
+
+func encodeJSONString(t *testing.T, envelope *Envelope) string {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	if err := envelope.EncodeJSON(&buffer); err != nil {
+		t.Fatalf("encode JSON: %v", err)
+	}
+
+	return strings.TrimSpace(buffer.String())
+}
+
+// sharedEnvelope returns an envelope of levels objects, each holding the one below twice,
+// so its root expands to 2^levels blocks.
+func sharedEnvelope(t *testing.T, levels int) *Envelope {
+	t.Helper()
+
+	var (
+		envelope *Envelope = NewEnvelope()
+		current  block.Block
+		err      error
+	)
+
+	if current, err = envelope.AddString("leaf"); err != nil {
+		t.Fatal(err)
+	}
+
+	for level := 0; level < levels; level++ {
+		if current, err = envelope.AddObject([]block.BlockAddress{current.Address(), current.Address()}); err != nil {
+			t.Fatal(err)
+		}
+
+		current.(*ObjectBlock).SetIsArray(true)
+	}
+
+	return envelope
+}
+
+func TestDecodeLimits(t *testing.T) {
+	var (
+		nested map[string]interface{} = map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"c": "d"}}}
+		long   map[string]interface{} = map[string]interface{}{"key": strings.Repeat("x", 100)}
+		shared []byte                 = marshalEnvelope(t, sharedEnvelope(t, 16))
+	)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		options DecodeOptions
+		isLimit bool
+	}{
+		{"no limits", marshalEnvelope(t, parseEnvelope(t, nested)), DecodeOptions{}, false},
+		{"max size", marshalEnvelope(t, parseEnvelope(t, long)), DecodeOptions{MaxSize: 64}, true},
+		{"max blocks", marshalEnvelope(t, parseEnvelope(t, nested)), DecodeOptions{MaxBlocks: 2}, true},
+		{"max blocks reached", marshalEnvelope(t, parseEnvelope(t, nested)), DecodeOptions{MaxBlocks: 4}, false},
+		{"max block size", marshalEnvelope(t, parseEnvelope(t, long)), DecodeOptions{MaxBlockSize: 64}, true},
+		{"max key length", marshalEnvelope(t, parseEnvelope(t, long)), DecodeOptions{MaxKeyLength: 2}, true},
+		{"max depth", marshalEnvelope(t, parseEnvelope(t, nested)), DecodeOptions{MaxDepth: 2}, true},
+		{"max depth reached", marshalEnvelope(t, parseEnvelope(t, nested)), DecodeOptions{MaxDepth: 3}, false},
+		{"max expanded blocks", shared, DecodeOptions{MaxExpandedBlocks: 10000}, true},
+		{"max expanded blocks reached", shared, DecodeOptions{MaxExpandedBlocks: 1 << 17}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewEnvelope().Decode(bytes.NewReader(test.data), test.options)
+
+			if test.isLimit && !errors.Is(err, block.ErrLimitExceeded) {
+				t.Fatalf("expected %v, got %v", block.ErrLimitExceeded, err)
+			}
+
+			if !test.isLimit && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReaderTraversalLimits(t *testing.T) {
+	var data []byte = marshalEnvelope(t, sharedEnvelope(t, 16))
+
+	tests := []struct {
+		name     string
+		options  DecodeOptions
+		traverse func(reader *Reader, root block.Block) error
+	}{
+		{"interface expanded", DecodeOptions{MaxExpandedBlocks: 10000}, func(_ *Reader, root block.Block) error {
+			_, err := root.Interface()
+			return err
+		}},
+		{"interface depth", DecodeOptions{MaxDepth: 8}, func(_ *Reader, root block.Block) error {
+			_, err := root.Interface()
+			return err
+		}},
+		{"json expanded", DecodeOptions{MaxExpandedBlocks: 10000}, func(reader *Reader, _ block.Block) error {
+			return reader.EncodeJSON(&bytes.Buffer{})
+		}},
+		{"walk expanded", DecodeOptions{MaxExpandedBlocks: 10000}, func(reader *Reader, root block.Block) error {
+			return reader.Walk(root, func([]interface{}, int, block.Block, *index.BlockIndex) error { return nil })
+		}},
+		{"walk depth", DecodeOptions{MaxDepth: 8}, func(reader *Reader, root block.Block) error {
+			return reader.Walk(root, func([]interface{}, int, block.Block, *index.BlockIndex) error { return nil })
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := NewReaderAt(bytes.NewReader(data), int64(len(data)), test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := reader.LoadRoot()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = test.traverse(reader, root); !errors.Is(err, block.ErrLimitExceeded) {
+				t.Fatalf("expected %v, got %v", block.ErrLimitExceeded, err)
+			}
+		})
+	}
+}
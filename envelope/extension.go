@@ -0,0 +1,554 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/deitas/apo/block"
+)
+
+// Extensions are envelopes flagged as extension in the header, patching a base envelope.
+// Their root object holds the content hash of the base, the key paths of values to delete
+// and an object of values to set. Objects to set are merged with objects of the base key
+// by key, any other value replaces the value of the base. Deletes are applied first, so
+// an object is replaced as a whole by deleting and setting it.
+const (
+	ExtensionBaseKey   = "base"
+	ExtensionDeleteKey = "delete"
+	ExtensionSetKey    = "set"
+)
+
+// ContentHash returns the SHA-256 hash of the encoded envelope, extensions reference their base by it.
+// The hash covers the whole encoding, header flags and the address size included, so envelopes
+// holding equal blocks but encoded with different options have different hashes.
+func (envelope *Envelope) ContentHash() (hash []byte, err error) {
+	var data []byte
+
+	if data, err = envelope.Marshal(); err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	hash = sum[:]
+
+	return
+}
+
+// NewExtension creates an extension of base setting the values of set, parsed by ParseBlock,
+// and deleting the values at deletePaths, e.g. []interface{}{"users", 3, "name"}.
+func NewExtension(base *Envelope, set interface{}, deletePaths ...[]interface{}) (extension *Envelope, err error) {
+	var (
+		baseHash    []byte
+		setBlock    block.Block
+		deleteBlock block.Block
+		deleteList  []interface{}
+	)
+
+	if baseHash, err = base.ContentHash(); err != nil {
+		return
+	}
+
+	extension = NewEnvelope(Options{
+		IsExtension:              true,
+		EnableMemoryOptimization: base.Header.EnableMemoryOptimization,
+	})
+
+	if set != nil {
+		if setBlock, err = extension.ParseBlock(set); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(deletePaths) > 0 {
+		for _, deletePath := range deletePaths {
+			deleteList = append(deleteList, deletePath)
+		}
+
+		if deleteBlock, err = extension.ParseBlock(deleteList); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = extension.Builder().Object(func(object *ObjectBuilder) {
+		object.Binary(ExtensionBaseKey, baseHash)
+
+		if deleteBlock != nil {
+			object.Block(ExtensionDeleteKey, deleteBlock)
+		}
+
+		if setBlock != nil {
+			object.Block(ExtensionSetKey, setBlock)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// ApplyExtensions returns a new envelope holding the root of base patched by extensions in order.
+// Every extension must reference either base or the result of the extensions before it.
+// Address blocks are replaced by copies of their targets.
+func ApplyExtensions(base *Envelope, extensions ...*Envelope) (merged *Envelope, err error) {
+	var (
+		baseHash    []byte
+		currentHash []byte
+		current     *Envelope = base
+		root        block.Block
+		deletes     *deleteNode
+		setBlock    block.Block
+	)
+
+	if len(extensions) > 0 {
+		if baseHash, err = base.ContentHash(); err != nil {
+			return
+		}
+
+		currentHash = baseHash
+	}
+
+	for cursor := 0; cursor == 0 || cursor < len(extensions); cursor++ {
+		if root, err = current.LoadRoot(); err != nil {
+			return nil, err
+		}
+
+		if root == nil {
+			return nil, fmt.Errorf("base envelope has no root block")
+		}
+
+		var limited []*Envelope = []*Envelope{base}
+
+		if cursor < len(extensions) {
+			var baseHashes [][]byte = [][]byte{baseHash, currentHash}
+
+			limited = append(limited, extensions[cursor])
+
+			if deletes, setBlock, err = extensions[cursor].decodeExtension(baseHashes); err != nil {
+				return nil, fmt.Errorf("extension %d: %w", cursor, err)
+			}
+		}
+
+		merged = NewEnvelope(Options{
+			EnableMemoryOptimization: base.Header.EnableMemoryOptimization,
+		})
+
+		if _, err = merged.mergeBlock(root, setBlock, deletes, newTraversal(limited...)); err != nil {
+			return nil, fmt.Errorf("extension %d: %w", cursor, err)
+		}
+
+		if current = merged; cursor < len(extensions)-1 {
+			if currentHash, err = merged.ContentHash(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return
+}
+
+// decodeExtension verifies the extension references one of baseHashes and returns its patch.
+func (envelope *Envelope) decodeExtension(baseHashes [][]byte) (deletes *deleteNode, setBlock block.Block, err error) {
+	var (
+		rootBlock block.Block
+		root      *ObjectBlock
+		isObject  bool
+		hashBlock block.Block
+		hasHash   bool
+		hash      []byte
+		isBase    bool
+	)
+
+	if !envelope.Header.IsExtension {
+		err = fmt.Errorf("envelope is not flagged as extension")
+		return
+	}
+
+	if rootBlock, err = envelope.LoadRoot(); err != nil {
+		return
+	}
+
+	if root, isObject = rootBlock.(*ObjectBlock); !isObject || root.IsArray() {
+		err = fmt.Errorf("extension root must be an object")
+		return
+	}
+
+	if hashBlock, hasHash = root.Lookup(ExtensionBaseKey); !hasHash {
+		err = fmt.Errorf("missing %q hash", ExtensionBaseKey)
+		return
+	}
+
+	switch typedBlock := hashBlock.(type) {
+	case *BinaryBlock:
+		hash, err = typedBlock.readAll()
+	case *StringBlock:
+		hash = typedBlock.Value
+	default:
+		err = fmt.Errorf("%w: %q hash must be binary", block.ErrInvalidType, ExtensionBaseKey)
+	}
+
+	if err != nil {
+		return
+	}
+
+	for _, baseHash := range baseHashes {
+		isBase = isBase || bytes.Equal(hash, baseHash)
+	}
+
+	if !isBase {
+		err = fmt.Errorf("base hash %x does not match the base envelope", hash)
+		return
+	}
+
+	if deletes, err = decodeDeletes(root); err != nil {
+		return
+	}
+
+	if setBlock, _ = root.Lookup(ExtensionSetKey); setBlock != nil {
+		setBlock, err = resolveAddress(setBlock)
+	}
+
+	return
+}
+
+// deleteNode is a tree of deleted key paths, keys are normalized by mergeKey.
+type deleteNode struct {
+	isDeleted bool
+	children  map[interface{}]*deleteNode
+}
+
+func (node *deleteNode) child(key interface{}) *deleteNode {
+	if node == nil {
+		return nil
+	}
+
+	return node.children[mergeKey(key)]
+}
+
+func (node *deleteNode) add(path []interface{}) {
+	for _, key := range path {
+		if node.children == nil {
+			node.children = map[interface{}]*deleteNode{}
+		}
+
+		next, hasNext := node.children[mergeKey(key)]
+		if !hasNext {
+			next = &deleteNode{}
+			node.children[mergeKey(key)] = next
+		}
+
+		node = next
+	}
+
+	node.isDeleted = true
+}
+
+func decodeDeletes(root *ObjectBlock) (deletes *deleteNode, err error) {
+	var (
+		deleteBlock block.Block
+		value       interface{}
+		paths       []interface{}
+		isList      bool
+	)
+
+	if deleteBlock, _ = root.Lookup(ExtensionDeleteKey); deleteBlock == nil {
+		return
+	}
+
+	if value, err = deleteBlock.Interface(); err != nil {
+		return
+	}
+
+	if paths, isList = value.([]interface{}); !isList {
+		err = fmt.Errorf("%w: %q must be an array of key paths", block.ErrInvalidType, ExtensionDeleteKey)
+		return
+	}
+
+	deletes = &deleteNode{}
+
+	for _, path := range paths {
+		keys, isPath := path.([]interface{})
+
+		if !isPath || len(keys) == 0 {
+			err = fmt.Errorf("%w: %q must be an array of key paths", block.ErrInvalidType, ExtensionDeleteKey)
+			return
+		}
+
+		for _, key := range keys {
+			switch mergeKey(key).(type) {
+			case string, int:
+			default:
+				err = fmt.Errorf("%w: %q keys must be strings or integers, got %T", block.ErrInvalidType, ExtensionDeleteKey, key)
+				return
+			}
+		}
+
+		deletes.add(keys)
+	}
+
+	return
+}
+
+// mergeKey converts integer keys to int, so keys match by type and value: 1 and "1" differ,
+// while decoded int64 keys of delete paths match the int keys of blocks.
+func mergeKey(key interface{}) interface{} {
+	switch typedKey := key.(type) {
+	case int64:
+		return int(typedKey)
+	case uint64:
+		return int(typedKey)
+	case int32:
+		return int(typedKey)
+	case uint32:
+		return int(typedKey)
+	default:
+		return key
+	}
+}
+
+func resolveAddress(current block.Block) (resolved block.Block, err error) {
+	if addressBlock, isAddress := current.(*AddressBlock); isAddress {
+		return addressBlock.Target()
+	}
+
+	return current, nil
+}
+
+// mergeBlock adds source patched by setBlock and deletes to the envelope, either of them may be nil.
+func (envelope *Envelope) mergeBlock(source block.Block, setBlock block.Block, deletes *deleteNode, traversal *traversal) (merged block.Block, err error) {
+	if err = traversal.expand(); err != nil {
+		return
+	}
+
+	if source, err = resolveAddress(source); err != nil {
+		return
+	}
+
+	if setBlock != nil {
+		if setBlock, err = resolveAddress(setBlock); err != nil {
+			return
+		}
+	}
+
+	sourceObject, isSourceObject := source.(*ObjectBlock)
+	setObject, isSetObject := setBlock.(*ObjectBlock)
+
+	switch {
+	case setBlock != nil && isSourceObject && isSetObject && !sourceObject.IsArray() && !setObject.IsArray():
+		return envelope.mergeObject(sourceObject, setObject, deletes, traversal)
+	case setBlock != nil:
+		return envelope.copyBlock(setBlock, traversal)
+	case isSourceObject && deletes != nil:
+		return envelope.mergeObject(sourceObject, nil, deletes, traversal)
+	default:
+		return envelope.copyBlock(source, traversal)
+	}
+}
+
+func (envelope *Envelope) mergeObject(source *ObjectBlock, setObject *ObjectBlock, deletes *deleteNode, traversal *traversal) (merged block.Block, err error) {
+	var (
+		addresses    []block.BlockAddress
+		baseKeys     map[interface{}]bool = map[interface{}]bool{}
+		setBlocks    map[interface{}]block.Block
+		setChildren  []Child
+		children     []Child
+		mergedObject *ObjectBlock
+	)
+
+	if err = traversal.enter(source); err != nil {
+		return
+	}
+
+	defer traversal.leave(source)
+
+	if children, err = source.LoadChildren(); err != nil {
+		return
+	}
+
+	if setObject != nil {
+		if setChildren, err = setObject.LoadChildren(); err != nil {
+			return
+		}
+
+		setBlocks = map[interface{}]block.Block{}
+
+		for _, child := range setChildren {
+			setBlocks[mergeKey(child.Key)] = child.Block
+		}
+	}
+
+	for _, child := range children {
+		var (
+			key        interface{} = mergeKey(child.Key)
+			childNode  *deleteNode = deletes.child(child.Key)
+			childBlock block.Block
+		)
+
+		if childNode != nil && childNode.isDeleted {
+			continue
+		}
+
+		baseKeys[key] = true
+
+		if childBlock, err = envelope.mergeBlock(child.Block, setBlocks[key], childNode, traversal); err != nil {
+			return
+		}
+
+		if err = setMergedKey(childBlock, source, child.Key, len(addresses)); err != nil {
+			return
+		}
+
+		addresses = append(addresses, childBlock.Address())
+	}
+
+	for _, child := range setChildren {
+		var childBlock block.Block
+
+		if baseKeys[mergeKey(child.Key)] {
+			continue
+		}
+
+		if childBlock, err = envelope.copyBlock(child.Block, traversal); err != nil {
+			return
+		}
+
+		if err = childBlock.SetKey(child.Key); err != nil {
+			return
+		}
+
+		addresses = append(addresses, childBlock.Address())
+	}
+
+	if mergedObject, err = envelope.AddObject(addresses); err != nil {
+		return
+	}
+
+	mergedObject.SetIsArray(source.IsArray())
+	copyFlags(source, mergedObject)
+
+	return mergedObject, nil
+}
+
+// setMergedKey keeps keys of object children, array items are renumbered after deletes.
+func setMergedKey(merged block.Block, parent *ObjectBlock, key interface{}, position int) error {
+	if parent.IsArray() {
+		return merged.SetKey(position)
+	}
+
+	return merged.SetKey(key)
+}
+
+// copyBlock adds a copy of the tree below source, which may belong to another envelope.
+// Blocks already copied by the traversal are reused, as shared children are in source.
+func (envelope *Envelope) copyBlock(source block.Block, traversal *traversal) (copied block.Block, err error) {
+	var isCopied bool
+
+	if copied, isCopied = traversal.copies[source]; isCopied {
+		return
+	}
+
+	if err = traversal.expand(); err != nil {
+		return
+	}
+
+	switch typedBlock := source.(type) {
+	case *AddressBlock:
+		if source, err = typedBlock.Target(); err != nil {
+			return
+		}
+
+		return envelope.copyBlock(source, traversal)
+	case *ObjectBlock:
+		var (
+			addresses    []block.BlockAddress
+			childBlock   block.Block
+			children     []Child
+			copiedObject *ObjectBlock
+		)
+
+		if children, err = typedBlock.LoadChildren(); err != nil {
+			return
+		}
+
+		if err = traversal.enter(typedBlock); err != nil {
+			return
+		}
+
+		defer traversal.leave(typedBlock)
+
+		for _, child := range children {
+			if childBlock, err = envelope.copyBlock(child.Block, traversal); err != nil {
+				return
+			}
+
+			if child.Key != nil {
+				if err = childBlock.SetKey(child.Key); err != nil {
+					return
+				}
+			}
+
+			addresses = append(addresses, childBlock.Address())
+		}
+
+		if copiedObject, err = envelope.AddObject(addresses); err != nil {
+			return
+		}
+
+		copiedObject.SetIsArray(typedBlock.IsArray())
+		copied = copiedObject
+	case *BinaryBlock:
+		var copiedBinary *BinaryBlock
+
+		if typedBlock.source != nil {
+			copiedBinary, err = envelope.AddBinaryReaderAt(typedBlock.source, typedBlock.source.Size())
+		} else {
+			copiedBinary, err = envelope.AddBinary(typedBlock.Data)
+		}
+
+		if err != nil {
+			return
+		}
+
+		copiedBinary.MIME = typedBlock.MIME
+		copiedBinary.Name = typedBlock.Name
+		copied = copiedBinary
+	case *StringBlock:
+		copied, err = envelope.AddString(typedBlock.Value)
+	case *EmptyBlock:
+		copied, err = envelope.AddEmpty()
+	case *IntBlock:
+		if copied, err = envelope.copyValue(source, typedBlock.Value); err == nil {
+			copied.(*IntBlock).SetIsNegative(typedBlock.IsNegative())
+		}
+	case *FloatBlock:
+		copied, err = envelope.copyValue(source, typedBlock.Value)
+	case *BooleanBlock:
+		copied, err = envelope.copyValue(source, []byte{typedBlock.Value})
+	default:
+		err = fmt.Errorf("%w: cannot copy %s block", block.ErrInvalidType, source.Type())
+	}
+
+	if err != nil {
+		return
+	}
+
+	copyFlags(source, copied)
+	traversal.copies[source] = copied
+
+	return
+}
+
+// copyValue adds a block decoded from the encoded value of source.
+func (envelope *Envelope) copyValue(source block.Block, value []byte) (copied block.Block, err error) {
+	if copied, err = envelope.decodeBlock(0, source.Type(), value); err != nil {
+		return
+	}
+
+	err = envelope.allocateBlock(copied)
+	return
+}
+
+func copyFlags(source block.Block, copied block.Block) {
+	copied.SetIsRequest(source.IsRequest())
+	copied.SetIsResponse(source.IsResponse())
+}
@@ -0,0 +1,124 @@
+package envelope
+
+import (
+	"testing"
+)
+
+func TestApplyExtensions(t *testing.T) {
+	var input map[string]interface{} = map[string]interface{}{
+		"name": "base",
+		"user": map[string]interface{}{"id": 1, "tags": []interface{}{"a", "b"}},
+	}
+
+	type extension struct {
+		set         interface{}
+		deletePaths [][]interface{}
+		// isChained extensions reference the result of the extensions before them
+		isChained bool
+		isForeign bool
+	}
+
+	tests := []struct {
+		name       string
+		extensions []extension
+		expected   string
+		isError    bool
+	}{
+		{"no extensions", nil, `{"name":"base","user":{"id":1,"tags":["a","b"]}}`, false},
+		{"set value", []extension{
+			{set: map[string]interface{}{"name": "patched"}},
+		}, `{"name":"patched","user":{"id":1,"tags":["a","b"]}}`, false},
+		{"merge nested object", []extension{
+			{set: map[string]interface{}{"user": map[string]interface{}{"email": "x"}}},
+		}, `{"name":"base","user":{"id":1,"tags":["a","b"],"email":"x"}}`, false},
+		{"delete nested value", []extension{
+			{deletePaths: [][]interface{}{{"user", "tags", 0}}},
+		}, `{"name":"base","user":{"id":1,"tags":["b"]}}`, false},
+		{"replace object", []extension{
+			{set: map[string]interface{}{"user": map[string]interface{}{"id": 2}}, deletePaths: [][]interface{}{{"user"}}},
+		}, `{"name":"base","user":{"id":2}}`, false},
+		{"chained extensions", []extension{
+			{set: map[string]interface{}{"name": "first"}},
+			{set: map[string]interface{}{"name": "second"}, isChained: true},
+		}, `{"name":"second","user":{"id":1,"tags":["a","b"]}}`, false},
+		{"extensions of the same base", []extension{
+			{set: map[string]interface{}{"name": "first"}},
+			{deletePaths: [][]interface{}{{"user"}}},
+		}, `{"name":"first"}`, false},
+		{"unknown base", []extension{
+			{set: map[string]interface{}{"name": "first"}},
+			{set: map[string]interface{}{"name": "unknown"}, isForeign: true},
+		}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				base       *Envelope = parseEnvelope(t, input)
+				current    *Envelope = base
+				extensions []*Envelope
+			)
+
+			for _, patch := range test.extensions {
+				if patch.isChained {
+					var err error
+
+					if current, err = ApplyExtensions(base, extensions...); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if patch.isForeign {
+					current = parseEnvelope(t, "foreign")
+				}
+
+				created, err := NewExtension(current, patch.set, patch.deletePaths...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				extensions = append(extensions, created)
+			}
+
+			merged, err := ApplyExtensions(base, extensions...)
+
+			if test.isError {
+				if err == nil {
+					t.Fatalf("expected error, got %s", encodeJSONString(t, merged))
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := encodeJSONString(t, merged); actual != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestApplyExtensionsSharedChildren(t *testing.T) {
+	var (
+		levels int       = 32
+		base   *Envelope = sharedEnvelope(t, levels)
+	)
+
+	extension, err := NewExtension(base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := ApplyExtensions(base, extension)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shared children are copied once instead of once per parent
+	if len(merged.Blocks) != levels+1 {
+		t.Fatalf("expected %d blocks, got %d", levels+1, len(merged.Blocks))
+	}
+}
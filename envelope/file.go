@@ -0,0 +1,311 @@
+package envelope
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+)
+
+// File is an envelope stored in a file, blocks are decoded on demand as by Reader.
+// Blocks allocated or modified since the last commit are appended in a journal
+// segment by Commit, Update overwrites a single block in place when possible.
+type File struct {
+	*Reader
+	writerAt     io.WriterAt
+	storedSize   int64
+	committed    int
+	addressBytes int
+	version      header.Version
+}
+
+// NewFile opens the envelope stored in storage, size is the current size of the storage.
+// Data left after the last journal footer by an interrupted commit is overwritten by the
+// next commit, and truncated when storage implements Truncate(int64) error as os.File does.
+// Commits are synced when storage implements Sync() error.
+func NewFile(storage interface {
+	io.ReaderAt
+	io.WriterAt
+}, size int64, options ...DecodeOptions) (file *File, err error) {
+	var reader *Reader
+
+	if reader, err = NewReaderAt(storage, size, options...); err != nil {
+		return
+	}
+
+	file = &File{
+		Reader:       reader,
+		writerAt:     storage,
+		storedSize:   size,
+		committed:    len(reader.Index.AllocatedAddresses),
+		addressBytes: reader.Header.AddressBytes,
+		version:      reader.Header.Version,
+	}
+
+	return
+}
+
+// Commit appends a segment with the blocks allocated since the last commit and the modified
+// blocks, including blocks whose key or flags changed. New blocks must fit the address size
+// the file was written with, otherwise the file has to be compacted first.
+func (file *File) Commit(modified ...block.Block) (err error) {
+	var (
+		entries       []encodeEntry
+		isEntry       map[block.BlockAddress]bool = map[block.BlockAddress]bool{}
+		segment       journalSegment
+		indexBuffer   bytes.Buffer
+		blocksBuffer  bytes.Buffer
+		data          []byte
+		headerBuffer  bytes.Buffer
+		blockOffset   int64
+		addedBlocks   []block.BlockAddress
+		modifiedBlock block.Block
+	)
+
+	if err = file.checkVersion(); err != nil {
+		return
+	}
+
+	if file.Header.AddressBytes != file.addressBytes {
+		err = fmt.Errorf("envelope needs %d address bytes, but the file was written with %d, compact it first", file.Header.AddressBytes, file.addressBytes)
+		return
+	}
+
+	addedBlocks = file.Index.AllocatedAddresses[file.committed:]
+
+	for _, modifiedBlock = range modified {
+		if _, hasBlockIndex := file.Index.LookupBlockIndex(modifiedBlock.Address()); !hasBlockIndex {
+			err = fmt.Errorf("%w: block %d is not allocated in the envelope", block.ErrUnknownAddress, modifiedBlock.Address())
+			return
+		}
+
+		if int(modifiedBlock.Address()) <= file.committed && !isEntry[modifiedBlock.Address()] {
+			blockIndex, _ := file.Index.LookupBlockIndex(modifiedBlock.Address())
+
+			entries = append(entries, encodeEntry{blockIndex: blockIndex, block: modifiedBlock})
+			isEntry[modifiedBlock.Address()] = true
+		}
+	}
+
+	for _, address := range addedBlocks {
+		blockIndex, _ := file.Index.LookupBlockIndex(address)
+
+		if addedBlock, hasBlock := file.Blocks.Lookup(address); hasBlock {
+			entries = append(entries, encodeEntry{blockIndex: blockIndex, block: addedBlock})
+		}
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	segment.offset = file.size
+	segment.previous = file.latestSegment
+
+	if segment.blocksChecksum, err = file.encodeBlocks(&blocksBuffer, entries); err != nil {
+		return
+	}
+
+	if segment.indexChecksum, err = file.encodeIndex(&indexBuffer, entries, nil); err != nil {
+		return
+	}
+
+	if int64(indexBuffer.Len()) >= 4294967296 {
+		err = fmt.Errorf("APO index exceeded maximum size of 4 GiB")
+		return
+	}
+
+	segment.indexSize = int64(indexBuffer.Len())
+
+	data = append(data, segment.encode()...)
+	data = append(data, indexBuffer.Bytes()...)
+	data = append(data, blocksBuffer.Bytes()...)
+
+	if err = file.truncate(); err != nil {
+		return
+	}
+
+	// the footer is written once the segment is stored, so readers never follow a footer to
+	// a partially written segment. Readers skip data following the last footer, as left by
+	// an interrupted commit, and the first commit sets the journal flag once its footer is stored.
+	if _, err = file.writerAt.WriteAt(data, segment.offset); err != nil {
+		return
+	}
+
+	if err = file.sync(); err != nil {
+		return
+	}
+
+	if _, err = file.writerAt.WriteAt(encodeJournalFooter(segment.offset), segment.offset+int64(len(data))); err != nil {
+		return
+	}
+
+	if err = file.sync(); err != nil {
+		return
+	}
+
+	data = append(data, encodeJournalFooter(segment.offset)...)
+
+	if !file.Header.HasJournal {
+		file.Header.HasJournal = true
+
+		if _, err = file.Header.Encode(&headerBuffer); err != nil {
+			return
+		}
+
+		if _, err = file.writerAt.WriteAt(headerBuffer.Bytes()[9:10], 9); err != nil {
+			return
+		}
+
+		if err = file.sync(); err != nil {
+			return
+		}
+	}
+
+	blockOffset = segment.offset + segmentHeaderSize + segment.indexSize
+
+	file.addRegion(block.SectionIndex, "segment header", segment.offset, segmentHeaderSize, 0)
+	file.addRegion(block.SectionIndex, "segment index", segment.offset+segmentHeaderSize, segment.indexSize, 0)
+
+	for _, entry := range entries {
+		file.offsets[entry.block.Address()] = blockOffset
+		file.addRegion(block.SectionBlocks, "block", blockOffset, int64(entry.blockIndex.BlockSize), entry.block.Address())
+		blockOffset += int64(entry.blockIndex.BlockSize)
+	}
+
+	file.sections = append(file.sections, segment.blocksSection(blockOffset))
+	file.addRegion(block.SectionIndex, "journal footer", blockOffset, journalFooterSize, 0)
+	file.size += int64(len(data))
+	file.storedSize = file.size
+	file.latestSegment = segment.offset
+	file.committed = len(file.Index.AllocatedAddresses)
+
+	return
+}
+
+// checkVersion refuses writing blocks in the current format to files of another layout,
+// versions of the current major only add to the format and are written to as is. The version
+// is the one read from storage, as encoding the envelope elsewhere stamps the current version.
+func (file *File) checkVersion() (err error) {
+	version := file.version
+
+	if version.Major != header.CurrentVersion.Major || version.Minor > header.CurrentVersion.Minor {
+		err = fmt.Errorf("%w: file was written with version %s, re-encode it with %s first", block.ErrUnsupportedVersion, version, header.CurrentVersion)
+	}
+
+	return
+}
+
+// truncate removes data left after the last journal footer by an interrupted commit.
+func (file *File) truncate() (err error) {
+	if file.storedSize <= file.size {
+		return
+	}
+
+	if truncater, isTruncater := file.writerAt.(interface{ Truncate(int64) error }); isTruncater {
+		if err = truncater.Truncate(file.size); err != nil {
+			return
+		}
+	}
+
+	file.storedSize = file.size
+	return
+}
+
+func (file *File) sync() error {
+	if syncer, isSyncer := file.writerAt.(interface{ Sync() error }); isSyncer {
+		return syncer.Sync()
+	}
+
+	return nil
+}
+
+// Update writes a modified block. Only a block whose encoding has exactly the size of the
+// stored one is overwritten in place, smaller and larger ones are relocated by Commit, as
+// block sizes are stored in the index. Changed keys or flags are stored in the index as
+// well, so they are written by Commit only.
+func (file *File) Update(updated block.Block) (err error) {
+	var (
+		blockBuffer bytes.Buffer
+		blockSize   int
+		offset      int64
+		hasOffset   bool
+		section     blocksSection
+		hasSection  bool
+	)
+
+	if err = file.checkVersion(); err != nil {
+		return
+	}
+
+	blockIndex, hasBlockIndex := file.Index.LookupBlockIndex(updated.Address())
+
+	if offset, hasOffset = file.offsets[updated.Address()]; !hasOffset || !hasBlockIndex {
+		return file.Commit(updated)
+	}
+
+	// blocks allocated since the last commit may be referenced by the updated block
+	if len(file.Index.AllocatedAddresses) > file.committed || file.Header.AddressBytes != file.addressBytes {
+		return file.Commit(updated)
+	}
+
+	if blockSize, err = updated.Encode(&blockBuffer); err != nil {
+		return
+	}
+
+	if int64(blockSize) != int64(blockIndex.BlockSize) {
+		return file.Commit(updated)
+	}
+
+	for _, section = range file.sections {
+		if hasSection = section.contains(offset); hasSection {
+			break
+		}
+	}
+
+	if !hasSection {
+		err = fmt.Errorf("%w: block %d is not in a checksummed section", block.ErrUnknownAddress, updated.Address())
+		return
+	}
+
+	if _, err = file.writerAt.WriteAt(blockBuffer.Bytes(), offset); err != nil {
+		return
+	}
+
+	file.Blocks.Set(updated.Address(), updated)
+
+	if err = file.updateChecksum(section); err != nil {
+		return
+	}
+
+	return file.sync()
+}
+
+// updateChecksum calculates the blocks checksum of section from the stored blocks and writes it.
+func (file *File) updateChecksum(section blocksSection) (err error) {
+	var (
+		checksumHash hash.Hash64 = header.NewChecksumHash()
+		checksum     header.Checksum
+	)
+
+	for _, span := range section.spans {
+		if _, err = io.Copy(checksumHash, io.NewSectionReader(file.readerAt, span.offset, span.size)); err != nil {
+			return
+		}
+	}
+
+	checksum = header.ChecksumFromHash(checksumHash)
+
+	if _, err = file.writerAt.WriteAt(checksum.Value, section.checksumOffset); err != nil {
+		return
+	}
+
+	if section.isBase {
+		file.Header.BlocksChecksum = checksum
+	}
+
+	return
+}
@@ -0,0 +1,120 @@
+package envelope
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fileStorage interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+func TestFileRecovery(t *testing.T) {
+	var data, _ = journalData(t, 2)
+
+	// the last commit was interrupted within its blocks
+	data = data[:len(data)-20]
+
+	storages := []struct {
+		name    string
+		storage func(file *os.File) fileStorage
+	}{
+		{"truncated storage", func(file *os.File) fileStorage {
+			return file
+		}},
+		{"overwritten storage", func(file *os.File) fileStorage {
+			// without Truncate, data left by the interrupted commit is overwritten
+			return struct{ fileStorage }{file}
+		}},
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(file *File, root *ObjectBlock) error
+		expected string
+	}{
+		{"commit", func(file *File, root *ObjectBlock) error {
+			added, err := file.AddString("recovered")
+			if err != nil {
+				return err
+			}
+
+			if err = added.SetKey(len(root.Values)); err != nil {
+				return err
+			}
+
+			root.AppendBlock(added)
+			return file.Commit(root)
+		}, `["first","event 0","recovered"]`},
+		{"update in place", func(file *File, root *ObjectBlock) error {
+			first, _ := root.Lookup(0)
+			first.(*StringBlock).Value = []byte("FIRST")
+			return file.Update(first)
+		}, `["FIRST","event 0"]`},
+		{"update relocated", func(file *File, root *ObjectBlock) error {
+			first, _ := root.Lookup(0)
+			first.(*StringBlock).Value = []byte("first!")
+			return file.Update(first)
+		}, `["first!","event 0"]`},
+	}
+
+	for _, storage := range storages {
+		for _, test := range tests {
+			t.Run(storage.name+"/"+test.name, func(t *testing.T) {
+				var name string = filepath.Join(t.TempDir(), "file.apo")
+
+				if err := ioutil.WriteFile(name, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				osFile, err := os.OpenFile(name, os.O_RDWR, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer osFile.Close()
+
+				file, err := NewFile(storage.storage(osFile), int64(len(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				root, err := file.LoadRoot()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err = test.modify(file, root.(*ObjectBlock)); err != nil {
+					t.Fatal(err)
+				}
+
+				stored, err := ioutil.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded := NewEnvelope()
+				if err = decoded.Decode(bytes.NewReader(stored)); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+
+				if actual := encodeJSONString(t, decoded); actual != test.expected {
+					t.Fatalf("decoded %s, expected %s", actual, test.expected)
+				}
+
+				reader, err := NewReaderAt(bytes.NewReader(stored), int64(len(stored)))
+				if err != nil {
+					t.Fatalf("read: %v", err)
+				}
+
+				if actual := encodeJSONString(t, reader.Envelope); actual != test.expected {
+					t.Fatalf("read %s, expected %s", actual, test.expected)
+				}
+			})
+		}
+	}
+}
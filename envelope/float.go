@@ -1,8 +1,11 @@
 package envelope
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"strconv"
 
 	"github.com/deitas/apo/block"
 	"github.com/deitas/apo/index"
@@ -16,11 +19,41 @@ func (envelope *Envelope) decodeFloat(address block.BlockAddress, buffer []byte)
 	floatBlock = &FloatBlock{
 		envelope: envelope,
 		address:  address,
-		Integer:  []byte{0x0},
-		Decimal:  []byte{0x0},
+		Value:    buffer,
 	}
 
-	// TODO: do someting with the buffer
+	if len(buffer) != 4 && len(buffer) != 8 {
+		err = fmt.Errorf("%w: float must be 4 or 8 bytes, got %d", block.ErrInvalidSize, len(buffer))
+		return
+	}
+
+	return
+}
+
+// decodeLegacyFloat decodes floats of 0.0 envelopes, stored as their "%f" formatting written twice.
+func (envelope *Envelope) decodeLegacyFloat(address block.BlockAddress, buffer []byte) (floatBlock *FloatBlock, err error) {
+	var (
+		text  string = string(buffer[:len(buffer)/2])
+		value float64
+	)
+
+	floatBlock = &FloatBlock{
+		envelope: envelope,
+		address:  address,
+	}
+
+	if len(buffer) == 0 || len(buffer)%2 != 0 || text != string(buffer[len(buffer)/2:]) {
+		err = fmt.Errorf("%w: legacy float must hold its text twice, got %d bytes", block.ErrInvalidSize, len(buffer))
+		return
+	}
+
+	if value, err = strconv.ParseFloat(text, 64); err != nil {
+		err = fmt.Errorf("invalid legacy float %q: %w", text, err)
+		return
+	}
+
+	floatBlock.Value = make([]byte, 8)
+	binary.LittleEndian.PutUint64(floatBlock.Value, math.Float64bits(value))
 
 	return
 }
@@ -30,13 +63,20 @@ func (envelope *Envelope) AddFloat(input interface{}) (floatBlock *FloatBlock, e
 		envelope: envelope,
 	}
 
+	/*
+		Size		Type		Encoding
+		---			---			---
+		4 bytes		float32		IEEE 754 binary32, little endian
+		8 bytes		float64		IEEE 754 binary64, little endian
+	*/
+
 	switch value := input.(type) {
 	case float32:
-		floatBlock.Integer = []byte(fmt.Sprintf("%f", value))
-		floatBlock.Decimal = []byte(fmt.Sprintf("%f", value))
+		floatBlock.Value = make([]byte, 4)
+		binary.LittleEndian.PutUint32(floatBlock.Value, math.Float32bits(value))
 	case float64:
-		floatBlock.Integer = []byte(fmt.Sprintf("%f", value))
-		floatBlock.Decimal = []byte(fmt.Sprintf("%f", value))
+		floatBlock.Value = make([]byte, 8)
+		binary.LittleEndian.PutUint64(floatBlock.Value, math.Float64bits(value))
 	default:
 		err = fmt.Errorf("invalid value type: %T", value)
 		return
@@ -50,8 +90,7 @@ func (envelope *Envelope) AddFloat(input interface{}) (floatBlock *FloatBlock, e
 type FloatBlock struct {
 	envelope *Envelope
 	address  block.BlockAddress
-	Integer  []byte
-	Decimal  []byte
+	Value    []byte
 }
 
 func (floatBlock *FloatBlock) Type() block.BlockType {
@@ -75,6 +114,10 @@ func (floatBlock *FloatBlock) SetKey(key interface{}) error {
 	return floatBlock.envelope.Index.SetKey(floatBlock.address, key)
 }
 
+func (floatBlock *FloatBlock) Parents() []block.Block {
+	return floatBlock.envelope.lookupParents(floatBlock.address)
+}
+
 func (floatBlock *FloatBlock) IsRequest() bool {
 	return floatBlock.envelope.Index.HasFlag(floatBlock.address, index.BitmaskRequest)
 }
@@ -103,15 +146,29 @@ func (floatBlock *FloatBlock) SetIsResponse(isResponse bool) block.Block {
 	return floatBlock
 }
 
+func (floatBlock *FloatBlock) Float64() (value float64, err error) {
+	switch len(floatBlock.Value) {
+	case 4:
+		value = float64(math.Float32frombits(binary.LittleEndian.Uint32(floatBlock.Value)))
+	case 8:
+		value = math.Float64frombits(binary.LittleEndian.Uint64(floatBlock.Value))
+	default:
+		err = fmt.Errorf("invalid float size")
+	}
+
+	return
+}
+
+func (floatBlock *FloatBlock) Interface() (interface{}, error) {
+	return floatBlock.Float64()
+}
+
 func (floatBlock *FloatBlock) Encode(writer io.Writer) (n int, err error) {
-	var data []byte
+	var addressData []byte
 
-	if data, err = floatBlock.address.ToBytes(floatBlock.envelope.Header.AddressBytes); err != nil {
+	if addressData, err = floatBlock.address.ToBytes(floatBlock.envelope.Header.AddressBytes); err != nil {
 		return
 	}
 
-	data = append(data, floatBlock.Integer...)
-	data = append(data, floatBlock.Decimal...)
-
-	return writer.Write(data)
+	return writer.Write(append(addressData, floatBlock.Value...))
 }
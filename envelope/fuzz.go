@@ -0,0 +1,36 @@
+//go:build gofuzz
+// +build gofuzz
+
+package envelope
+
+import (
+	"bytes"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/index"
+)
+
+// Fuzz is the entry point for go-fuzz, seeded from testdata/fuzz/corpus:
+//
+//	go-fuzz-build && go-fuzz -workdir=testdata/fuzz
+func Fuzz(data []byte) int {
+	var envelope *Envelope = NewEnvelope()
+
+	if err := envelope.Decode(bytes.NewReader(data)); err != nil {
+		return 0
+	}
+
+	envelope.TraverseAllBlocks(func(traversedBlock block.Block, _ *index.BlockIndex) {
+		traversedBlock.Interface()
+	})
+
+	envelope.Walk(envelope.Root(), func(_ []interface{}, _ int, _ block.Block, _ *index.BlockIndex) error {
+		return nil
+	})
+
+	if _, err := envelope.Marshal(); err != nil {
+		return 0
+	}
+
+	return 1
+}
@@ -0,0 +1,133 @@
+package envelope
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"sort"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+)
+
+// Region is a part of the encoded data located by a Reader, see Regions.
+type Region struct {
+	Name    string
+	Section block.Section
+	Offset  int64
+	Size    int64
+	// Address is set for regions belonging to a block.
+	Address block.BlockAddress
+}
+
+// SectionChecksum compares a stored checksum with the one calculated from the data it covers.
+type SectionChecksum struct {
+	Section    block.Section
+	Offset     int64
+	Stored     header.Checksum
+	Calculated header.Checksum
+}
+
+func (checksum SectionChecksum) IsValid() bool {
+	return bytes.Equal(checksum.Stored.Value, checksum.Calculated.Value)
+}
+
+func (reader *Reader) addRegion(section block.Section, name string, offset int64, size int64, address block.BlockAddress) {
+	reader.regions = append(reader.regions, Region{
+		Name:    name,
+		Section: section,
+		Offset:  offset,
+		Size:    size,
+		Address: address,
+	})
+}
+
+// Regions returns the parts of the encoded data in the order of their offsets,
+// including blocks replaced by later journal segments.
+func (reader *Reader) Regions() (regions []Region) {
+	regions = append(regions, reader.regions...)
+
+	sort.SliceStable(regions, func(i int, j int) bool {
+		return regions[i].Offset < regions[j].Offset
+	})
+
+	return
+}
+
+// Checksums calculates the index and blocks checksums of the envelope and of every journal segment.
+func (reader *Reader) Checksums() (checksums []SectionChecksum, err error) {
+	for _, section := range reader.sections {
+		var (
+			storedData    []byte
+			indexHash     hash.Hash64 = header.NewChecksumHash()
+			blocksHash    hash.Hash64 = header.NewChecksumHash()
+			indexChecksum SectionChecksum
+		)
+
+		if storedData, err = reader.readAt(section.checksumOffset-8, 16, block.SectionIndex); err != nil {
+			return
+		}
+
+		if err = reader.hashSpan(indexHash, section.metadataSpan, block.SectionHeader); err != nil {
+			return
+		}
+
+		if err = reader.hashSpan(indexHash, section.indexSpan, block.SectionIndex); err != nil {
+			return
+		}
+
+		for _, span := range section.spans {
+			if err = reader.hashSpan(blocksHash, span, block.SectionBlocks); err != nil {
+				return
+			}
+		}
+
+		indexChecksum = SectionChecksum{
+			Section:    block.SectionIndex,
+			Offset:     section.checksumOffset - 8,
+			Stored:     header.Checksum{Value: storedData[0:8]},
+			Calculated: header.ChecksumFromHash(indexHash),
+		}
+
+		checksums = append(checksums, indexChecksum, SectionChecksum{
+			Section:    block.SectionBlocks,
+			Offset:     section.checksumOffset,
+			Stored:     header.Checksum{Value: storedData[8:16]},
+			Calculated: header.ChecksumFromHash(blocksHash),
+		})
+	}
+
+	return
+}
+
+func (reader *Reader) hashSpan(checksumHash hash.Hash64, span blocksSpan, section block.Section) (err error) {
+	var data []byte
+
+	if data, err = reader.readAt(span.offset, span.size, section); err != nil {
+		return
+	}
+
+	checksumHash.Write(data)
+	return
+}
+
+// Verify reports the first stored checksum not matching the data it covers.
+func (reader *Reader) Verify() (err error) {
+	var checksums []SectionChecksum
+
+	if checksums, err = reader.Checksums(); err != nil {
+		return
+	}
+
+	for _, checksum := range checksums {
+		if !checksum.IsValid() {
+			return &block.DecodeError{
+				Offset:  checksum.Offset,
+				Section: checksum.Section,
+				Err:     fmt.Errorf("%w: stored %x, calculated %x", block.ErrChecksumMismatch, checksum.Stored.Value, checksum.Calculated.Value),
+			}
+		}
+	}
+
+	return
+}
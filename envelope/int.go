@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 
 	"github.com/deitas/apo/block"
@@ -185,6 +186,10 @@ func (intBlock *IntBlock) SetKey(key interface{}) error {
 	return intBlock.envelope.Index.SetKey(intBlock.address, key)
 }
 
+func (intBlock *IntBlock) Parents() []block.Block {
+	return intBlock.envelope.lookupParents(intBlock.address)
+}
+
 func (intBlock *IntBlock) IsRequest() bool {
 	return intBlock.envelope.Index.HasFlag(intBlock.address, index.BitmaskRequest)
 }
@@ -227,6 +232,80 @@ func (intBlock *IntBlock) SetIsNegative(isArray bool) block.Block {
 	return intBlock
 }
 
+func (intBlock *IntBlock) magnitude() (magnitude uint64, err error) {
+	if len(intBlock.Value) > 8 {
+		err = fmt.Errorf("int block exceeded maximum size of 8 bytes")
+		return
+	}
+
+	for cursor := len(intBlock.Value) - 1; cursor >= 0; cursor-- {
+		magnitude = magnitude<<8 | uint64(intBlock.Value[cursor])
+	}
+
+	return
+}
+
+func (intBlock *IntBlock) Int64() (value int64, err error) {
+	var magnitude uint64
+
+	if magnitude, err = intBlock.magnitude(); err != nil {
+		return
+	}
+
+	if intBlock.IsNegative() {
+		if magnitude > uint64(math.MaxInt64)+1 {
+			err = fmt.Errorf("value -%d overflows int64", magnitude)
+			return
+		}
+
+		value = int64(-magnitude)
+		return
+	}
+
+	if magnitude > math.MaxInt64 {
+		err = fmt.Errorf("value %d overflows int64", magnitude)
+		return
+	}
+
+	value = int64(magnitude)
+	return
+}
+
+func (intBlock *IntBlock) Uint64() (value uint64, err error) {
+	if value, err = intBlock.magnitude(); err != nil {
+		return
+	}
+
+	if intBlock.IsNegative() && value != 0 {
+		err = fmt.Errorf("value -%d overflows uint64", value)
+		value = 0
+		return
+	}
+
+	return
+}
+
+// Interface returns the value as int64, or as uint64 when it does not fit into int64.
+func (intBlock *IntBlock) Interface() (value interface{}, err error) {
+	var magnitude uint64
+
+	if intBlock.IsNegative() {
+		return intBlock.Int64()
+	}
+
+	if magnitude, err = intBlock.magnitude(); err != nil {
+		return
+	}
+
+	if magnitude > math.MaxInt64 {
+		value = magnitude
+		return
+	}
+
+	value = int64(magnitude)
+	return
+}
+
 func (intBlock *IntBlock) Encode(writer io.Writer) (n int, err error) {
 	var addressData []byte
 
@@ -0,0 +1,155 @@
+package envelope
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+	"github.com/deitas/apo/index"
+)
+
+// Envelopes with a journal (flagged in the header) are followed by segments appended
+// after the encoded envelope, each one ending with a footer pointing to the latest segment:
+//
+//	segment signature			4 bytes
+//	previous segment offset	8 bytes, 0 for the first segment
+//	index size				4 bytes
+//	index checksum			8 bytes
+//	blocks checksum			8 bytes
+//	index					index size
+//	blocks					in the order of the index
+//	footer signature			4 bytes
+//	latest segment offset		8 bytes
+//
+// Index entries of later segments replace entries with the same address, so
+// blocks are updated by appending them again. Header checksums cover the
+// encoded envelope only, every segment has checksums of its own.
+
+const (
+	segmentSignature       string = "\x89APS"
+	journalFooterSignature string = "\x89APF"
+	segmentHeaderSize      int64  = 32
+	journalFooterSize      int64  = 12
+)
+
+type journalSegment struct {
+	offset         int64
+	previous       int64
+	indexSize      int64
+	indexChecksum  header.Checksum
+	blocksChecksum header.Checksum
+}
+
+func (segment journalSegment) encode() (data []byte) {
+	data = make([]byte, segmentHeaderSize)
+
+	copy(data[0:4], segmentSignature)
+	binary.LittleEndian.PutUint64(data[4:12], uint64(segment.previous))
+	binary.LittleEndian.PutUint32(data[12:16], uint32(segment.indexSize))
+	copy(data[16:24], segment.indexChecksum.Value)
+	copy(data[24:32], segment.blocksChecksum.Value)
+
+	return
+}
+
+// blocksSection locates the blocks of the segment, blocksEnd is the offset following its last block.
+func (segment journalSegment) blocksSection(blocksEnd int64) blocksSection {
+	var blocksOffset int64 = segment.offset + segmentHeaderSize + segment.indexSize
+
+	return blocksSection{
+		indexSpan:      blocksSpan{offset: segment.offset + segmentHeaderSize, size: segment.indexSize},
+		spans:          []blocksSpan{{offset: blocksOffset, size: blocksEnd - blocksOffset}},
+		checksumOffset: segment.offset + 24,
+	}
+}
+
+func decodeJournalSegment(offset int64, data []byte) (segment journalSegment, err error) {
+	if string(data[0:4]) != segmentSignature {
+		err = &block.DecodeError{
+			Offset:  offset,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: missing journal segment signature", block.ErrNotAPO),
+		}
+		return
+	}
+
+	segment = journalSegment{
+		offset:         offset,
+		previous:       int64(binary.LittleEndian.Uint64(data[4:12])),
+		indexSize:      int64(binary.LittleEndian.Uint32(data[12:16])),
+		indexChecksum:  header.Checksum{Value: data[16:24]},
+		blocksChecksum: header.Checksum{Value: data[24:32]},
+	}
+
+	// segments only point backwards, so following them always ends
+	if segment.previous < 0 || segment.previous >= offset {
+		err = &block.DecodeError{
+			Offset:  offset + 4,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: previous journal segment at offset %d", block.ErrInvalidSize, segment.previous),
+		}
+	}
+
+	return
+}
+
+func encodeJournalFooter(latestSegment int64) (data []byte) {
+	data = make([]byte, journalFooterSize)
+
+	copy(data[0:4], journalFooterSignature)
+	binary.LittleEndian.PutUint64(data[4:12], uint64(latestSegment))
+
+	return
+}
+
+func decodeJournalFooter(offset int64, data []byte) (latestSegment int64, err error) {
+	if string(data[0:4]) != journalFooterSignature {
+		err = &block.DecodeError{
+			Offset:  offset,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: missing journal footer signature", block.ErrNotAPO),
+		}
+		return
+	}
+
+	latestSegment = int64(binary.LittleEndian.Uint64(data[4:12]))
+	return
+}
+
+// mergeSegmentIndex decodes the index of a segment and applies it to the envelope index.
+func (envelope *Envelope) mergeSegmentIndex(segment journalSegment, data []byte, options DecodeOptions) (delta *index.Index, err error) {
+	if delta, err = envelope.decodeSegmentDelta(segment, data, options); err != nil {
+		return
+	}
+
+	err = envelope.mergeSegmentDelta(segment, delta, options)
+	return
+}
+
+func (envelope *Envelope) decodeSegmentDelta(segment journalSegment, data []byte, options DecodeOptions) (delta *index.Index, err error) {
+	delta = index.NewIndex()
+	err = delta.DecodeEntries(envelope.Header, data, segment.offset+segmentHeaderSize, options.indexLimits())
+	return
+}
+
+func (envelope *Envelope) mergeSegmentDelta(segment journalSegment, delta *index.Index, options DecodeOptions) (err error) {
+	if err = envelope.Index.Merge(delta); err != nil {
+		err = &block.DecodeError{
+			Offset:  segment.offset + segmentHeaderSize,
+			Section: block.SectionIndex,
+			Err:     err,
+		}
+		return
+	}
+
+	if options.MaxBlocks > 0 && len(envelope.Index.AllocatedAddresses) > options.MaxBlocks {
+		err = &block.DecodeError{
+			Offset:  segment.offset + segmentHeaderSize,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: more than %d blocks", block.ErrLimitExceeded, options.MaxBlocks),
+		}
+	}
+
+	return
+}
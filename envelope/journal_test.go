@@ -0,0 +1,135 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// journalData returns an envelope holding an array of strings, with one string appended
+// by each commit, and the JSON of the array before the first and after every commit.
+func journalData(t *testing.T, commits int) (data []byte, states []string) {
+	t.Helper()
+
+	var values []string = []string{"first"}
+
+	storage, err := os.Create(filepath.Join(t.TempDir(), "journal.apo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	if err = parseEnvelope(t, []interface{}{"first"}).Encode(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	states = append(states, jsonState(t, values))
+
+	for commit := 0; commit < commits; commit++ {
+		var value string = fmt.Sprintf("event %d", commit)
+
+		stat, err := storage.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := NewFile(storage, stat.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		root, err := file.LoadRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		added, err := file.AddString(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = added.SetKey(len(root.(*ObjectBlock).Values)); err != nil {
+			t.Fatal(err)
+		}
+
+		root.(*ObjectBlock).AppendBlock(added)
+
+		if err = file.Commit(root); err != nil {
+			t.Fatal(err)
+		}
+
+		values = append(values, value)
+		states = append(states, jsonState(t, values))
+	}
+
+	if data, err = ioutil.ReadFile(storage.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func jsonState(t *testing.T, values []string) string {
+	t.Helper()
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	var (
+		data, states = journalData(t, 3)
+		// the footer of the second segment ends where the third segment starts
+		lastSegment = bytes.LastIndex(data[:len(data)-int(journalFooterSize)], []byte(journalFooterSignature)) + int(journalFooterSize)
+		unflagged   []byte
+	)
+
+	unflagged, _ = journalData(t, 1)
+	unflagged[9] &^= 0x1
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"intact", data, states[3]},
+		{"missing footer", data[:len(data)-int(journalFooterSize)], states[2]},
+		{"truncated segment", data[:len(data)-20], states[2]},
+		{"segment header only", data[:lastSegment+int(segmentHeaderSize)], states[2]},
+		{"broken footer", append(append([]byte{}, data[:len(data)-int(journalFooterSize)]...), "\x89XPF"+string(data[len(data)-8:])...), states[2]},
+		{"data after footer", append(append([]byte{}, data...), "garbage"...), states[3]},
+		{"segment without footer after footer", append(append([]byte{}, data...), data[lastSegment:len(data)-int(journalFooterSize)]...), states[3]},
+		{"first commit without journal flag", unflagged, states[0]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var decoded *Envelope = NewEnvelope()
+
+			if err := decoded.Decode(bytes.NewReader(test.data)); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			if actual := encodeJSONString(t, decoded); actual != test.expected {
+				t.Fatalf("decoded %s, expected %s", actual, test.expected)
+			}
+
+			reader, err := NewReaderAt(bytes.NewReader(test.data), int64(len(test.data)))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+
+			if actual := encodeJSONString(t, reader.Envelope); actual != test.expected {
+				t.Fatalf("read %s, expected %s", actual, test.expected)
+			}
+		})
+	}
+}
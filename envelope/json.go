@@ -0,0 +1,159 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/deitas/apo/block"
+)
+
+type JSONOptions struct {
+	// Indent is repeated for every nesting level, the output is compact when empty.
+	Indent string
+	// SortKeys orders keys of objects instead of keeping the order of their blocks.
+	SortKeys bool
+}
+
+// EncodeJSON writes the root block as JSON. Address blocks are replaced by their targets,
+// binary blocks are written as base64 strings and empty blocks as null.
+func (envelope *Envelope) EncodeJSON(writer io.Writer, options ...JSONOptions) (err error) {
+	var (
+		jsonOptions JSONOptions
+		buffer      bytes.Buffer
+		indented    bytes.Buffer
+		root        block.Block
+	)
+
+	if len(options) > 0 {
+		jsonOptions = options[0]
+	}
+
+	if root, err = envelope.LoadRoot(); err != nil {
+		return
+	}
+
+	if root == nil {
+		err = fmt.Errorf("envelope has no root block")
+		return
+	}
+
+	if err = envelope.encodeJSONBlock(&buffer, root, jsonOptions, newTraversal(envelope)); err != nil {
+		return
+	}
+
+	if jsonOptions.Indent != "" {
+		if err = json.Indent(&indented, buffer.Bytes(), "", jsonOptions.Indent); err != nil {
+			return
+		}
+
+		buffer = indented
+	}
+
+	buffer.WriteString("\n")
+
+	_, err = buffer.WriteTo(writer)
+	return
+}
+
+// MarshalJSON implements json.Marshaler, see EncodeJSON.
+func (envelope *Envelope) MarshalJSON() (data []byte, err error) {
+	var buffer bytes.Buffer
+
+	if err = envelope.EncodeJSON(&buffer); err != nil {
+		return
+	}
+
+	data = bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+	return
+}
+
+func (envelope *Envelope) encodeJSONBlock(buffer *bytes.Buffer, current block.Block, options JSONOptions, traversal *traversal) (err error) {
+	var (
+		value interface{}
+		data  []byte
+	)
+
+	if err = traversal.expand(); err != nil {
+		return
+	}
+
+	switch typedBlock := current.(type) {
+	case *AddressBlock:
+		if current, err = typedBlock.Target(); err != nil {
+			return
+		}
+
+		return envelope.encodeJSONBlock(buffer, current, options, traversal)
+	case *ObjectBlock:
+		var children []Child
+
+		if children, err = typedBlock.LoadChildren(); err != nil {
+			return
+		}
+
+		if err = traversal.enter(typedBlock); err != nil {
+			return
+		}
+
+		defer traversal.leave(typedBlock)
+
+		if typedBlock.IsArray() {
+			buffer.WriteString("[")
+
+			for cursor, child := range children {
+				if cursor > 0 {
+					buffer.WriteString(",")
+				}
+
+				if err = envelope.encodeJSONBlock(buffer, child.Block, options, traversal); err != nil {
+					return
+				}
+			}
+
+			buffer.WriteString("]")
+			return
+		}
+
+		if options.SortKeys {
+			sort.SliceStable(children, func(i int, j int) bool {
+				return fmt.Sprint(children[i].Key) < fmt.Sprint(children[j].Key)
+			})
+		}
+
+		buffer.WriteString("{")
+
+		for cursor, child := range children {
+			if cursor > 0 {
+				buffer.WriteString(",")
+			}
+
+			if data, err = json.Marshal(fmt.Sprint(child.Key)); err != nil {
+				return
+			}
+
+			buffer.Write(data)
+			buffer.WriteString(":")
+
+			if err = envelope.encodeJSONBlock(buffer, child.Block, options, traversal); err != nil {
+				return
+			}
+		}
+
+		buffer.WriteString("}")
+		return
+	default:
+		if value, err = current.Interface(); err != nil {
+			return
+		}
+
+		if data, err = json.Marshal(value); err != nil {
+			return
+		}
+
+		buffer.Write(data)
+		return
+	}
+}
@@ -1,10 +1,10 @@
 package envelope
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 
 	"github.com/deitas/apo/block"
 	"github.com/deitas/apo/index"
@@ -18,14 +18,18 @@ func (envelope *Envelope) parseStringMap(input map[string]interface{}) (objectBl
 	var (
 		itemBlock     block.Block
 		itemAddresses []block.BlockAddress
+		itemKeys      []string = make([]string, 0, len(input))
 	)
 
-	for itemKey, itemValue := range input {
-		if itemBlock, err = envelope.ParseBlock(itemValue); err != nil {
-			return
-		}
+	// keys are parsed in sorted order, so equal maps are always encoded the same way
+	for itemKey := range input {
+		itemKeys = append(itemKeys, itemKey)
+	}
+
+	sort.Strings(itemKeys)
 
-		if err = itemBlock.SetKey(itemKey); err != nil {
+	for _, itemKey := range itemKeys {
+		if itemBlock, err = envelope.parseItem(itemKey, input[itemKey]); err != nil {
 			return
 		}
 
@@ -59,11 +63,7 @@ func (envelope *Envelope) parseStruct(input reflect.Value) (objectBlock *ObjectB
 		fieldValue = input.Field(index)
 
 		if fieldValue.CanInterface() {
-			if itemBlock, err = envelope.ParseBlock(fieldValue.Interface()); err != nil {
-				return
-			}
-
-			if err = itemBlock.SetKey(itemKey); err != nil {
+			if itemBlock, err = envelope.parseItem(itemKey, fieldValue.Interface()); err != nil {
 				return
 			}
 
@@ -85,11 +85,7 @@ func (envelope *Envelope) parseArray(input []interface{}) (objectBlock *ObjectBl
 	)
 
 	for itemKey, itemValue := range input {
-		if itemBlock, err = envelope.ParseBlock(itemValue); err != nil {
-			return
-		}
-
-		if err = itemBlock.SetKey(itemKey); err != nil {
+		if itemBlock, err = envelope.parseItem(itemKey, itemValue); err != nil {
 			return
 		}
 
@@ -100,6 +96,8 @@ func (envelope *Envelope) parseArray(input []interface{}) (objectBlock *ObjectBl
 		return
 	}
 
+	objectBlock.SetIsArray(true)
+
 	return
 }
 
@@ -118,11 +116,7 @@ func (envelope *Envelope) parseSliceOrArray(input reflect.Value) (objectBlock *O
 				continue
 			}
 
-			if itemBlock, err = envelope.ParseBlock(itemValue.Interface()); err != nil {
-				return
-			}
-
-			if err = itemBlock.SetKey(itemKey); err != nil {
+			if itemBlock, err = envelope.parseItem(itemKey, itemValue.Interface()); err != nil {
 				return
 			}
 
@@ -141,6 +135,66 @@ func (envelope *Envelope) parseSliceOrArray(input reflect.Value) (objectBlock *O
 	return
 }
 
+func (envelope *Envelope) resolveInterface(current block.Block, traversal *traversal) (value interface{}, err error) {
+	if err = traversal.expand(); err != nil {
+		return
+	}
+
+	switch typedBlock := current.(type) {
+	case *AddressBlock:
+		if current, err = typedBlock.Target(); err != nil {
+			return
+		}
+
+		return envelope.resolveInterface(current, traversal)
+	case *ObjectBlock:
+		var (
+			children   []Child
+			childValue interface{}
+		)
+
+		if children, err = typedBlock.LoadChildren(); err != nil {
+			return
+		}
+
+		if err = traversal.enter(typedBlock); err != nil {
+			return
+		}
+
+		defer traversal.leave(typedBlock)
+
+		if typedBlock.IsArray() {
+			values := make([]interface{}, 0, len(children))
+
+			for _, child := range children {
+				if childValue, err = envelope.resolveInterface(child.Block, traversal); err != nil {
+					return
+				}
+
+				values = append(values, childValue)
+			}
+
+			value = values
+			return
+		}
+
+		values := make(map[string]interface{}, len(children))
+
+		for _, child := range children {
+			if childValue, err = envelope.resolveInterface(child.Block, traversal); err != nil {
+				return
+			}
+
+			values[fmt.Sprint(child.Key)] = childValue
+		}
+
+		value = values
+		return
+	default:
+		return current.Interface()
+	}
+}
+
 func (envelope *Envelope) decodeObject(address block.BlockAddress, buffer []byte) (objectBlock *ObjectBlock, err error) {
 	objectBlock = &ObjectBlock{
 		envelope: envelope,
@@ -149,44 +203,19 @@ func (envelope *Envelope) decodeObject(address block.BlockAddress, buffer []byte
 	}
 
 	if len(buffer)%envelope.Header.AddressBytes != 0 {
-		err = fmt.Errorf("invalid address sizes")
+		err = fmt.Errorf("%w: object values must be multiple of %d bytes", block.ErrInvalidSize, envelope.Header.AddressBytes)
 		return
 	}
 
 	for cursor := 0; cursor < len(buffer); cursor += envelope.Header.AddressBytes {
-		var (
-			addressBuffer []byte
-			address       block.BlockAddress
-		)
+		var address block.BlockAddress
 
-		switch envelope.Header.AddressBytes {
-		case 1:
-			addressBuffer = []byte{buffer[cursor], 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-		case 2:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1]}
-			address = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-		case 3:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1], buffer[cursor+2], 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-		case 4:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1], buffer[cursor+2], buffer[cursor+3]}
-			address = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-		case 5:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1], buffer[cursor+2], buffer[cursor+3], buffer[cursor+4], 0x0, 0x0, 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-		case 6:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1], buffer[cursor+2], buffer[cursor+3], buffer[cursor+4], buffer[cursor+5], 0x0, 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-		case 7:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1], buffer[cursor+2], buffer[cursor+3], buffer[cursor+4], buffer[cursor+5], buffer[cursor+6], 0x0}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-		case 8:
-			addressBuffer = []byte{buffer[cursor], buffer[cursor+1], buffer[cursor+2], buffer[cursor+3], buffer[cursor+4], buffer[cursor+5], buffer[cursor+6], buffer[cursor+7]}
-			address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
+		if address, err = block.DecodeBlockAddress(buffer[cursor:], envelope.Header.AddressBytes); err != nil {
+			return
 		}
 
 		objectBlock.Values = append(objectBlock.Values, address)
+		envelope.linkParent(address, objectBlock.address)
 	}
 
 	return
@@ -208,7 +237,13 @@ func (envelope *Envelope) AddObject(input interface{}) (objectBlock *ObjectBlock
 		return
 	}
 
-	err = envelope.allocateBlock(objectBlock)
+	if err = envelope.allocateBlock(objectBlock); err != nil {
+		return
+	}
+
+	for _, address := range objectBlock.Values {
+		envelope.linkParent(address, objectBlock.address)
+	}
 
 	return
 }
@@ -219,6 +254,11 @@ type ObjectBlock struct {
 	Values   []block.BlockAddress
 }
 
+type Child struct {
+	Key   interface{}
+	Block block.Block
+}
+
 func (objectBlock *ObjectBlock) Type() block.BlockType {
 	return block.Object
 }
@@ -240,11 +280,55 @@ func (objectBlock *ObjectBlock) SetKey(key interface{}) error {
 	return objectBlock.envelope.Index.SetKey(objectBlock.address, key)
 }
 
+func (objectBlock *ObjectBlock) Parents() []block.Block {
+	return objectBlock.envelope.lookupParents(objectBlock.address)
+}
+
 func (objectBlock *ObjectBlock) AppendBlock(block block.Block) block.Block {
 	objectBlock.Values = append(objectBlock.Values, block.Address())
+	objectBlock.envelope.linkParent(block.Address(), objectBlock.address)
 	return objectBlock
 }
 
+// Children returns the key and block of every value of the object block,
+// skipping values whose block is not present in the envelope or fails to load.
+func (objectBlock *ObjectBlock) Children() (children []Child) {
+	for _, address := range objectBlock.Values {
+		if child, hasChild := objectBlock.envelope.LookupBlock(address); hasChild {
+			children = append(children, Child{
+				Key:   child.Key(),
+				Block: child,
+			})
+		}
+	}
+
+	return
+}
+
+// LoadChildren returns the children like Children, but reports the error of
+// a value whose block fails to load instead of skipping it.
+func (objectBlock *ObjectBlock) LoadChildren() (children []Child, err error) {
+	var (
+		child    block.Block
+		hasChild bool
+	)
+
+	for _, address := range objectBlock.Values {
+		if child, hasChild, err = objectBlock.envelope.lookupBlock(address); err != nil {
+			return nil, err
+		}
+
+		if hasChild {
+			children = append(children, Child{
+				Key:   child.Key(),
+				Block: child,
+			})
+		}
+	}
+
+	return
+}
+
 func (objectBlock *ObjectBlock) IsRequest() bool {
 	return objectBlock.envelope.Index.HasFlag(objectBlock.address, index.BitmaskRequest)
 }
@@ -287,6 +371,32 @@ func (objectBlock *ObjectBlock) SetIsArray(isArray bool) block.Block {
 	return objectBlock
 }
 
+// Lookup returns the value of the object block stored under key.
+func (objectBlock *ObjectBlock) Lookup(key interface{}) (child block.Block, hasChild bool) {
+	// keys are strings or ints, other keys match no child and could not be compared
+	switch key = mergeKey(key); key.(type) {
+	case string, int:
+	default:
+		return
+	}
+
+	for _, address := range objectBlock.Values {
+		if objectBlock.envelope.Index.GetKey(address) != key {
+			continue
+		}
+
+		return objectBlock.envelope.LookupBlock(address)
+	}
+
+	return
+}
+
+// Interface returns the object block as []interface{} when flagged as array,
+// or as map[string]interface{} otherwise, resolving all nested blocks.
+func (objectBlock *ObjectBlock) Interface() (interface{}, error) {
+	return objectBlock.envelope.resolveInterface(objectBlock, newTraversal(objectBlock.envelope))
+}
+
 func (objectBlock *ObjectBlock) Encode(writer io.Writer) (n int, err error) {
 	var (
 		data        []byte
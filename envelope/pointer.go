@@ -1,23 +1,49 @@
 package envelope
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/deitas/apo/block"
 )
 
-func (envelope *Envelope) parsePointer(input interface{}) (block block.Block, err error) {
-	var inputValue reflect.Value = reflect.ValueOf(input)
+// parsedPointer identifies a pointer dereferenced by ParseBlock, the type is part of it
+// because a struct and its first field share the same address.
+type parsedPointer struct {
+	pointerType reflect.Type
+	pointer     uintptr
+}
+
+func (envelope *Envelope) parsePointer(input interface{}) (parsedBlock block.Block, err error) {
+	var (
+		inputValue reflect.Value = reflect.ValueOf(input)
+		pointer    parsedPointer
+	)
 
 	if inputValue.IsNil() {
-		block, err = envelope.ParseBlock(nil)
+		parsedBlock, err = envelope.ParseBlock(nil)
 		return
 	}
 
+	pointer = parsedPointer{pointerType: inputValue.Type(), pointer: inputValue.Pointer()}
+
+	// a pointer reached again while it is dereferenced would be followed without end
+	if envelope.parsePointers[pointer] {
+		err = envelope.parseError(fmt.Errorf("%w: cyclic pointer of type %s", block.ErrLimitExceeded, inputValue.Type()))
+		return
+	}
+
+	if envelope.parsePointers == nil {
+		envelope.parsePointers = map[parsedPointer]bool{}
+	}
+
+	envelope.parsePointers[pointer] = true
+	defer delete(envelope.parsePointers, pointer)
+
 	inputValue = inputValue.Elem()
 
 	if inputValue.CanInterface() {
-		block, err = envelope.ParseBlock(inputValue.Interface())
+		parsedBlock, err = envelope.ParseBlock(inputValue.Interface())
 	}
 
 	return
@@ -0,0 +1,620 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+	"github.com/deitas/apo/index"
+)
+
+// Reader is an envelope backed by an io.ReaderAt. Only the header and the index
+// are decoded upfront, blocks are decoded when they are looked up for the first time.
+//
+// Parent links are recorded when object blocks are decoded, so Parents only accounts for
+// the object blocks loaded so far, Root loads every object block to find the root.
+// DecodeOptions.MaxDepth and MaxExpandedBlocks are enforced while Walk, Interface and
+// EncodeJSON traverse the blocks, since Block decodes a single block without knowing how
+// deep it is nested.
+//
+// When the io.ReaderAt also provides its whole content through a Bytes() []byte
+// method, as memory mapped files do, string and binary blocks refer to that content
+// without copying and must not be used after Close.
+type Reader struct {
+	*Envelope
+	readerAt      io.ReaderAt
+	data          []byte
+	size          int64
+	headerSize    int64
+	baseSize      int64
+	latestSegment int64
+	options       DecodeOptions
+	offsets       map[block.BlockAddress]int64
+	sections      []blocksSection
+	regions       []Region
+}
+
+// blocksSection locates stored blocks covered by one blocks checksum.
+// The index checksum is stored right before the blocks checksum, the index
+// checksum of the base also covers the metadata section.
+type blocksSection struct {
+	metadataSpan   blocksSpan
+	indexSpan      blocksSpan
+	spans          []blocksSpan
+	checksumOffset int64
+	isBase         bool
+}
+
+type blocksSpan struct {
+	offset int64
+	size   int64
+}
+
+func (section blocksSection) contains(offset int64) bool {
+	for _, span := range section.spans {
+		if offset >= span.offset && offset < span.offset+span.size {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewReaderAt(readerAt io.ReaderAt, size int64, options ...DecodeOptions) (reader *Reader, err error) {
+	reader = &Reader{
+		Envelope: NewEnvelope(),
+		readerAt: readerAt,
+		size:     size,
+		baseSize: size,
+		offsets:  map[block.BlockAddress]int64{},
+	}
+
+	if len(options) > 0 {
+		reader.options = options[0]
+		reader.Envelope.decodeOptions = options[0]
+	}
+
+	if bytesReader, isBytesReader := readerAt.(interface{ Bytes() []byte }); isBytesReader {
+		reader.data = bytesReader.Bytes()
+	}
+
+	reader.Envelope.loadBlock = reader.Block
+
+	if err = reader.decodeIndex(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (reader *Reader) readAt(offset int64, size int64, section block.Section) (data []byte, err error) {
+	if offset < 0 || size < 0 || offset > reader.size || size > reader.size-offset {
+		err = &block.DecodeError{
+			Offset:  offset,
+			Section: section,
+			Err:     block.ErrTruncated,
+		}
+		return
+	}
+
+	if reader.data != nil && int64(len(reader.data)) >= offset+size {
+		data = reader.data[offset : offset+size]
+		return
+	}
+
+	data = make([]byte, size)
+
+	if read, readErr := reader.readerAt.ReadAt(data, offset); int64(read) == size {
+		err = nil
+	} else if readErr == nil || readErr == io.EOF {
+		err = &block.DecodeError{
+			Offset:  offset + int64(read),
+			Section: section,
+			Err:     block.ErrTruncated,
+		}
+	} else {
+		err = readErr
+	}
+
+	return
+}
+
+func (reader *Reader) decodeIndex() (err error) {
+	var (
+		headerData []byte
+		segments   []journalSegment
+	)
+
+	if reader.options.MaxSize > 0 && reader.size > reader.options.MaxSize {
+		err = &block.DecodeError{
+			Section: block.SectionHeader,
+			Err:     fmt.Errorf("%w: data is larger than %d bytes", block.ErrLimitExceeded, reader.options.MaxSize),
+		}
+		return
+	}
+
+	if headerData, err = reader.readAt(0, 26, block.SectionHeader); err != nil {
+		return
+	}
+
+	if err = reader.Header.Decode(headerData); err != nil {
+		return
+	}
+
+	if err = reader.useVersion(); err != nil {
+		return
+	}
+
+	reader.headerSize = 26
+	reader.addRegion(block.SectionHeader, "header", 0, 26, 0)
+
+	if reader.Header.HasMetadata {
+		if err = reader.decodeMetadata(); err != nil {
+			return
+		}
+	}
+
+	if reader.Header.HasJournal {
+		if segments, err = reader.decodeSegmentChain(); err != nil {
+			return
+		}
+
+		reader.baseSize = segments[0].offset
+	}
+
+	if err = reader.decodeBaseIndex(); err != nil {
+		return
+	}
+
+	for cursor, segment := range segments {
+		var segmentEnd int64 = reader.size - journalFooterSize
+
+		if cursor+1 < len(segments) {
+			segmentEnd = segments[cursor+1].offset - journalFooterSize
+		}
+
+		if err = reader.decodeSegmentIndex(segment, segmentEnd); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// decodeMetadata reads the metadata section following the header.
+func (reader *Reader) decodeMetadata() (err error) {
+	var (
+		sizeData     []byte
+		metadataData []byte
+	)
+
+	if sizeData, err = reader.readAt(26, 4, block.SectionHeader); err != nil {
+		return
+	}
+
+	if metadataData, err = reader.readAt(30, int64(binary.LittleEndian.Uint32(sizeData)), block.SectionHeader); err != nil {
+		return
+	}
+
+	if reader.Metadata, err = header.DecodeMetadata(metadataData); err != nil {
+		return
+	}
+
+	reader.headerSize = 30 + int64(len(metadataData))
+	reader.addRegion(block.SectionHeader, "metadata", 26, 4+int64(len(metadataData)), 0)
+
+	return
+}
+
+// decodeBaseIndex decodes the index of the envelope preceding journal segments.
+func (reader *Reader) decodeBaseIndex() (err error) {
+	var (
+		sizeData    []byte
+		indexData   []byte
+		indexOffset int64 = reader.headerSize + 4
+		indexSize   int64
+		blockOffset int64
+	)
+
+	if reader.Header.HasTrailerIndex {
+		return reader.decodeTrailerIndex()
+	}
+
+	if sizeData, err = reader.readAt(reader.headerSize, 4, block.SectionIndex); err != nil {
+		return
+	}
+
+	indexSize = int64(binary.LittleEndian.Uint32(sizeData))
+
+	if indexData, err = reader.readAt(indexOffset, indexSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if err = reader.Index.DecodeEntries(reader.Header, indexData, indexOffset, reader.options.indexLimits()); err != nil {
+		return
+	}
+
+	if err = reader.Index.CheckAddresses(); err != nil {
+		return
+	}
+
+	reader.addRegion(block.SectionIndex, "index size", reader.headerSize, 4, 0)
+	reader.addRegion(block.SectionIndex, "index", indexOffset, indexSize, 0)
+
+	// blocks are stored in the order of the index, so offsets follow from the block sizes
+	blockOffset = indexOffset + indexSize
+
+	for _, address := range reader.Index.AllocatedAddresses {
+		blockIndex, _ := reader.Index.LookupBlockIndex(address)
+
+		reader.offsets[address] = blockOffset
+		reader.addRegion(block.SectionBlocks, "block", blockOffset, int64(blockIndex.BlockSize), address)
+		blockOffset += int64(blockIndex.BlockSize)
+	}
+
+	if blockOffset > reader.baseSize {
+		err = &block.DecodeError{
+			Offset:  reader.baseSize,
+			Section: block.SectionBlocks,
+			Err:     fmt.Errorf("%w: index declares %d bytes of blocks", block.ErrTruncated, blockOffset-indexOffset-indexSize),
+		}
+		return
+	}
+
+	reader.sections = append(reader.sections, blocksSection{
+		metadataSpan:   blocksSpan{offset: 26, size: reader.headerSize - 26},
+		indexSpan:      blocksSpan{offset: indexOffset, size: indexSize},
+		spans:          []blocksSpan{{offset: indexOffset + indexSize, size: blockOffset - indexOffset - indexSize}},
+		checksumOffset: 18,
+		isBase:         true,
+	})
+
+	return
+}
+
+func (reader *Reader) decodeTrailerIndex() (err error) {
+	var (
+		footerData  []byte
+		indexData   []byte
+		indexSize   int64
+		indexOffset int64
+		sizesData   []byte
+		blockOffset int64 = reader.headerSize
+	)
+
+	if footerData, err = reader.readAt(reader.baseSize-trailerFooterSize, trailerFooterSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	indexSize = int64(binary.LittleEndian.Uint32(footerData[16:20]))
+	indexOffset = reader.baseSize - trailerFooterSize - indexSize
+
+	// the index is preceded by the zero size ending the blocks and by the index size
+	if sizesData, err = reader.readAt(indexOffset-8, 8, block.SectionIndex); err != nil {
+		return
+	}
+
+	if binary.LittleEndian.Uint32(sizesData[0:4]) != 0 || int64(binary.LittleEndian.Uint32(sizesData[4:8])) != indexSize {
+		err = &block.DecodeError{
+			Offset:  indexOffset - 8,
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: trailer index sizes do not match", block.ErrInvalidSize),
+		}
+		return
+	}
+
+	if indexData, err = reader.readAt(indexOffset, indexSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if err = reader.Index.DecodeEntries(reader.Header, indexData, indexOffset, reader.options.indexLimits()); err != nil {
+		return
+	}
+
+	if err = reader.Index.CheckAddresses(); err != nil {
+		return
+	}
+
+	reader.Header.IndexChecksum = header.Checksum{Value: footerData[0:8]}
+	reader.Header.BlocksChecksum = header.Checksum{Value: footerData[8:16]}
+
+	section := blocksSection{
+		metadataSpan:   blocksSpan{offset: 26, size: reader.headerSize - 26},
+		indexSpan:      blocksSpan{offset: indexOffset, size: indexSize},
+		checksumOffset: reader.baseSize - trailerFooterSize + 8,
+		isBase:         true,
+	}
+
+	// blocks are written in the order of the index, each one prefixed with its size
+	for _, address := range reader.Index.AllocatedAddresses {
+		blockIndex, _ := reader.Index.LookupBlockIndex(address)
+
+		reader.offsets[address] = blockOffset + 4
+		section.spans = append(section.spans, blocksSpan{offset: blockOffset + 4, size: int64(blockIndex.BlockSize)})
+		reader.addRegion(block.SectionBlocks, "block size", blockOffset, 4, address)
+		reader.addRegion(block.SectionBlocks, "block", blockOffset+4, int64(blockIndex.BlockSize), address)
+		blockOffset += 4 + int64(blockIndex.BlockSize)
+	}
+
+	reader.sections = append(reader.sections, section)
+	reader.addRegion(block.SectionIndex, "blocks end, index size", indexOffset-8, 8, 0)
+	reader.addRegion(block.SectionIndex, "index", indexOffset, indexSize, 0)
+	reader.addRegion(block.SectionIndex, "trailer footer", reader.baseSize-trailerFooterSize, trailerFooterSize, 0)
+
+	if blockOffset != indexOffset-8 {
+		err = &block.DecodeError{
+			Offset:  indexOffset - 8,
+			Section: block.SectionBlocks,
+			Err:     fmt.Errorf("%w: index declares %d bytes of blocks, found %d", block.ErrInvalidSize, blockOffset-reader.headerSize, indexOffset-8-reader.headerSize),
+		}
+		return
+	}
+
+	return
+}
+
+// decodeSegmentChain follows journal segments from the last intact footer, returning them from
+// the oldest. Data following that footer was left by an interrupted commit, it is not read.
+func (reader *Reader) decodeSegmentChain() (segments []journalSegment, err error) {
+	var (
+		end      int64 = reader.size
+		chainErr error
+		hasEnd   bool
+	)
+
+	for {
+		if segments, chainErr = reader.decodeSegmentChainAt(end); chainErr == nil {
+			reader.size = end
+			return segments, nil
+		}
+
+		if err == nil {
+			err = chainErr
+		}
+
+		if end, hasEnd, chainErr = reader.previousFooterEnd(end); chainErr != nil {
+			return nil, chainErr
+		}
+
+		if !hasEnd {
+			return nil, err
+		}
+	}
+}
+
+// decodeSegmentChainAt follows journal segments from the footer ending at end, verifying
+// that the blocks of the latest segment end right at the footer.
+func (reader *Reader) decodeSegmentChainAt(end int64) (segments []journalSegment, err error) {
+	var (
+		footerData    []byte
+		segmentData   []byte
+		segment       journalSegment
+		segmentOffset int64
+	)
+
+	if footerData, err = reader.readAt(end-journalFooterSize, journalFooterSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if segmentOffset, err = decodeJournalFooter(end-journalFooterSize, footerData); err != nil {
+		return
+	}
+
+	reader.latestSegment = segmentOffset
+
+	for {
+		if segmentOffset < reader.headerSize || segmentOffset >= end {
+			err = &block.DecodeError{
+				Offset:  segmentOffset,
+				Section: block.SectionIndex,
+				Err:     fmt.Errorf("%w: journal segment at offset %d is outside of the data", block.ErrInvalidSize, segmentOffset),
+			}
+			return
+		}
+
+		if segmentData, err = reader.readAt(segmentOffset, segmentHeaderSize, block.SectionIndex); err != nil {
+			return
+		}
+
+		if segment, err = decodeJournalSegment(segmentOffset, segmentData); err != nil {
+			return
+		}
+
+		if len(segments) == 0 {
+			if err = reader.expectSegmentEnd(segment, end-journalFooterSize); err != nil {
+				return
+			}
+		}
+
+		segments = append([]journalSegment{segment}, segments...)
+
+		if segment.previous == 0 {
+			return
+		}
+
+		segmentOffset = segment.previous
+	}
+}
+
+// expectSegmentEnd verifies that the blocks declared by the index of segment end at segmentEnd.
+func (reader *Reader) expectSegmentEnd(segment journalSegment, segmentEnd int64) (err error) {
+	var (
+		indexData   []byte
+		delta       *index.Index = index.NewIndex()
+		blockOffset int64        = segment.offset + segmentHeaderSize + segment.indexSize
+	)
+
+	if indexData, err = reader.readAt(segment.offset+segmentHeaderSize, segment.indexSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if err = delta.DecodeEntries(reader.Header, indexData, segment.offset+segmentHeaderSize, reader.options.indexLimits()); err != nil {
+		return
+	}
+
+	for _, address := range delta.AllocatedAddresses {
+		blockIndex, _ := delta.LookupBlockIndex(address)
+		blockOffset += int64(blockIndex.BlockSize)
+	}
+
+	if blockOffset != segmentEnd {
+		err = &block.DecodeError{
+			Offset:  segment.offset,
+			Section: block.SectionBlocks,
+			Err:     fmt.Errorf("%w: journal segment declares %d bytes, found %d", block.ErrInvalidSize, blockOffset-segment.offset, segmentEnd-segment.offset),
+		}
+	}
+
+	return
+}
+
+// previousFooterEnd returns the end of the last journal footer signature found before end.
+func (reader *Reader) previousFooterEnd(end int64) (footerEnd int64, hasFooter bool, err error) {
+	const chunkSize int64 = 64 * 1024
+
+	var (
+		// the footer ending at end was tried already, so the signature starts before it
+		chunkEnd   int64 = end - journalFooterSize - 1 + int64(len(journalFooterSignature))
+		chunkStart int64
+		chunkData  []byte
+	)
+
+	for chunkEnd-int64(len(journalFooterSignature)) >= reader.headerSize {
+		if chunkStart = chunkEnd - chunkSize; chunkStart < reader.headerSize {
+			chunkStart = reader.headerSize
+		}
+
+		if chunkData, err = reader.readAt(chunkStart, chunkEnd-chunkStart, block.SectionIndex); err != nil {
+			return
+		}
+
+		if cursor := bytes.LastIndex(chunkData, []byte(journalFooterSignature)); cursor >= 0 {
+			return chunkStart + int64(cursor) + journalFooterSize, true, nil
+		}
+
+		// chunks overlap, so a signature split between two chunks is found
+		chunkEnd = chunkStart + int64(len(journalFooterSignature)) - 1
+	}
+
+	return
+}
+
+func (reader *Reader) decodeSegmentIndex(segment journalSegment, segmentEnd int64) (err error) {
+	var (
+		indexData   []byte
+		delta       *index.Index
+		blockOffset int64 = segment.offset + segmentHeaderSize + segment.indexSize
+	)
+
+	if indexData, err = reader.readAt(segment.offset+segmentHeaderSize, segment.indexSize, block.SectionIndex); err != nil {
+		return
+	}
+
+	if delta, err = reader.mergeSegmentIndex(segment, indexData, reader.options); err != nil {
+		return
+	}
+
+	reader.addRegion(block.SectionIndex, "segment header", segment.offset, segmentHeaderSize, 0)
+	reader.addRegion(block.SectionIndex, "segment index", segment.offset+segmentHeaderSize, segment.indexSize, 0)
+
+	for _, address := range delta.AllocatedAddresses {
+		blockIndex, _ := delta.LookupBlockIndex(address)
+
+		reader.offsets[address] = blockOffset
+		reader.addRegion(block.SectionBlocks, "block", blockOffset, int64(blockIndex.BlockSize), address)
+		blockOffset += int64(blockIndex.BlockSize)
+	}
+
+	if blockOffset != segmentEnd {
+		err = &block.DecodeError{
+			Offset:  segment.offset,
+			Section: block.SectionBlocks,
+			Err:     fmt.Errorf("%w: journal segment declares %d bytes, found %d", block.ErrInvalidSize, blockOffset-segment.offset, segmentEnd-segment.offset),
+		}
+		return
+	}
+
+	reader.sections = append(reader.sections, segment.blocksSection(blockOffset))
+	reader.addRegion(block.SectionIndex, "journal footer", segmentEnd, journalFooterSize, 0)
+
+	return
+}
+
+// Block returns the block with address, decoding it on the first lookup. Its parents
+// are not known until the object blocks referencing it are loaded.
+func (reader *Reader) Block(address block.BlockAddress) (loadedBlock block.Block, err error) {
+	var (
+		hasBlock      bool
+		hasOffset     bool
+		offset        int64
+		blockData     []byte
+		storedAddress block.BlockAddress
+	)
+
+	if loadedBlock, hasBlock = reader.Blocks.Lookup(address); hasBlock {
+		return
+	}
+
+	blockIndex, hasBlockIndex := reader.Index.LookupBlockIndex(address)
+
+	if offset, hasOffset = reader.offsets[address]; !hasOffset || !hasBlockIndex {
+		err = &block.DecodeError{
+			Section: block.SectionBlocks,
+			Address: address,
+			Err:     block.ErrUnknownAddress,
+		}
+		return
+	}
+
+	if int64(blockIndex.BlockSize) < int64(reader.Header.AddressBytes) {
+		err = &block.DecodeError{
+			Offset:    offset,
+			Section:   block.SectionBlocks,
+			Address:   address,
+			BlockType: blockIndex.Type,
+			Key:       blockIndex.Key,
+			Err:       fmt.Errorf("%w: block size %d is smaller than address", block.ErrInvalidSize, blockIndex.BlockSize),
+		}
+		return
+	}
+
+	if blockData, err = reader.readAt(offset, int64(blockIndex.BlockSize), block.SectionBlocks); err != nil {
+		return
+	}
+
+	if storedAddress, err = block.DecodeBlockAddress(blockData, reader.Header.AddressBytes); err == nil && storedAddress != address {
+		err = fmt.Errorf("%w: block at offset is stored with address %d", block.ErrUnknownAddress, storedAddress)
+	}
+
+	if err == nil {
+		loadedBlock, err = reader.decodeVersionBlock(address, blockIndex.Type, blockData[reader.Header.AddressBytes:])
+	}
+
+	if err != nil {
+		err = &block.DecodeError{
+			Offset:    offset,
+			Section:   block.SectionBlocks,
+			Address:   address,
+			BlockType: blockIndex.Type,
+			Key:       blockIndex.Key,
+			Err:       err,
+		}
+		return
+	}
+
+	reader.Blocks.Set(address, loadedBlock)
+
+	return
+}
+
+// Close closes the underlying io.ReaderAt when it implements io.Closer.
+func (reader *Reader) Close() error {
+	if closer, isCloser := reader.readerAt.(io.Closer); isCloser {
+		return closer.Close()
+	}
+
+	return nil
+}
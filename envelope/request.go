@@ -0,0 +1,198 @@
+package envelope
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/index"
+)
+
+// Requests are object blocks flagged as request, holding a correlation ID, the name
+// of a method and its arguments. Many requests can be put into one envelope. Responses
+// are object blocks flagged as response, holding the ID of the request they answer and
+// either a result or an error message.
+const (
+	RequestIDKey      = "id"
+	RequestMethodKey  = "method"
+	RequestArgsKey    = "args"
+	ResponseResultKey = "result"
+	ResponseErrorKey  = "error"
+)
+
+// AddRequest adds a request for method with args parsed by ParseBlock. The id correlates
+// the request with its response, it must be unique within the envelope.
+// Blocks allocated for the request are removed again when it cannot be added.
+func (envelope *Envelope) AddRequest(id interface{}, method string, args interface{}) (request *ObjectBlock, err error) {
+	var (
+		idBlock   block.Block
+		argsBlock block.Block
+		allocated int = len(envelope.Index.AllocatedAddresses)
+	)
+
+	defer func() {
+		if err != nil {
+			envelope.releaseBlocks(allocated)
+			request = nil
+		}
+	}()
+
+	if idBlock, err = envelope.ParseBlock(id); err != nil {
+		return
+	}
+
+	if argsBlock, err = envelope.ParseBlock(args); err != nil {
+		return
+	}
+
+	if request, err = envelope.Builder().Object(func(object *ObjectBuilder) {
+		object.Block(RequestIDKey, idBlock).String(RequestMethodKey, method).Block(RequestArgsKey, argsBlock)
+	}); err != nil {
+		return
+	}
+
+	request.SetIsRequest(true)
+	return
+}
+
+// AddResponse adds the response to request, which is usually held by another envelope.
+// The result is parsed by ParseBlock, unless responseErr is set and replaces it.
+// Blocks allocated for the response are removed again when it cannot be added.
+func (envelope *Envelope) AddResponse(request *ObjectBlock, result interface{}, responseErr error) (response *ObjectBlock, err error) {
+	var (
+		id          interface{}
+		idBlock     block.Block
+		resultBlock block.Block
+		allocated   int = len(envelope.Index.AllocatedAddresses)
+	)
+
+	defer func() {
+		if err != nil {
+			envelope.releaseBlocks(allocated)
+			response = nil
+		}
+	}()
+
+	if id, err = RequestID(request); err != nil {
+		return
+	}
+
+	if idBlock, err = envelope.ParseBlock(id); err != nil {
+		return
+	}
+
+	if responseErr == nil {
+		if resultBlock, err = envelope.ParseBlock(result); err != nil {
+			return
+		}
+	}
+
+	if response, err = envelope.Builder().Object(func(object *ObjectBuilder) {
+		object.Block(RequestIDKey, idBlock)
+
+		if responseErr != nil {
+			object.String(ResponseErrorKey, responseErr.Error())
+			return
+		}
+
+		object.Block(ResponseResultKey, resultBlock)
+	}); err != nil {
+		return
+	}
+
+	response.SetIsResponse(true)
+	return
+}
+
+// Requests returns the object blocks flagged as request in the order of the index.
+func (envelope *Envelope) Requests() (requests []*ObjectBlock) {
+	envelope.TraverseRequests(func(current block.Block, _ *index.BlockIndex) {
+		if request, isObject := current.(*ObjectBlock); isObject {
+			requests = append(requests, request)
+		}
+	})
+
+	return
+}
+
+// Responses returns the object blocks flagged as response in the order of the index.
+func (envelope *Envelope) Responses() (responses []*ObjectBlock) {
+	envelope.TraverseResponses(func(current block.Block, _ *index.BlockIndex) {
+		if response, isObject := current.(*ObjectBlock); isObject {
+			responses = append(responses, response)
+		}
+	})
+
+	return
+}
+
+// ResponseMap holds responses by the ID of the request they answer, see ResponsesByID.
+type ResponseMap map[interface{}]*ObjectBlock
+
+// For returns the response with the ID of request.
+func (responses ResponseMap) For(request *ObjectBlock) (response *ObjectBlock, hasResponse bool) {
+	var (
+		id    interface{}
+		hasID bool
+	)
+
+	if id, hasID = mapRequestID(request); !hasID {
+		return
+	}
+
+	response, hasResponse = responses[id]
+	return
+}
+
+// ResponsesByID maps the responses of the envelope by their ID once, so the responses to many
+// requests are found without scanning the envelope for every request. The first response to
+// an ID is kept, responses without an ID or with an ID which is not comparable are left out.
+func (envelope *Envelope) ResponsesByID() (responses ResponseMap) {
+	responses = ResponseMap{}
+
+	for _, response := range envelope.Responses() {
+		if id, hasID := mapRequestID(response); hasID {
+			if _, hasResponse := responses[id]; !hasResponse {
+				responses[id] = response
+			}
+		}
+	}
+
+	return
+}
+
+// ResponseFor returns the response of the envelope with the ID of request, use ResponsesByID
+// for the responses to many requests.
+func (envelope *Envelope) ResponseFor(request *ObjectBlock) (response *ObjectBlock, hasResponse bool) {
+	return envelope.ResponsesByID().For(request)
+}
+
+// mapRequestID returns the ID of a request or a response when it can be used as a map key.
+func mapRequestID(objectBlock *ObjectBlock) (id interface{}, hasID bool) {
+	var err error
+
+	if id, err = RequestID(objectBlock); err != nil || id == nil {
+		return
+	}
+
+	hasID = reflect.TypeOf(id).Comparable()
+	return
+}
+
+// RequestID returns the correlation ID of a request or a response, nil when it has none.
+func RequestID(objectBlock *ObjectBlock) (id interface{}, err error) {
+	var (
+		idBlock block.Block
+		hasID   bool
+	)
+
+	if idBlock, hasID = objectBlock.Lookup(RequestIDKey); !hasID {
+		return
+	}
+
+	if id, err = idBlock.Interface(); err != nil {
+		err = fmt.Errorf("request ID: %w", err)
+	}
+
+	return
+}
@@ -9,6 +9,17 @@ import (
 )
 
 func (envelope *Envelope) parseString(input interface{}) (stringBlock *StringBlock, err error) {
+	switch value := input.(type) {
+	case string:
+		err = envelope.addParsePayload(len(value))
+	case []byte:
+		err = envelope.addParsePayload(len(value))
+	}
+
+	if err != nil {
+		return
+	}
+
 	return envelope.AddString(input)
 }
 
@@ -69,6 +80,10 @@ func (stringBlock *StringBlock) SetKey(key interface{}) error {
 	return stringBlock.envelope.Index.SetKey(stringBlock.address, key)
 }
 
+func (stringBlock *StringBlock) Parents() []block.Block {
+	return stringBlock.envelope.lookupParents(stringBlock.address)
+}
+
 func (stringBlock *StringBlock) IsRequest() bool {
 	return stringBlock.envelope.Index.HasFlag(stringBlock.address, index.BitmaskRequest)
 }
@@ -97,6 +112,14 @@ func (stringBlock *StringBlock) SetIsResponse(isResponse bool) block.Block {
 	return stringBlock
 }
 
+func (stringBlock *StringBlock) String() string {
+	return string(stringBlock.Value)
+}
+
+func (stringBlock *StringBlock) Interface() (interface{}, error) {
+	return stringBlock.String(), nil
+}
+
 func (stringBlock *StringBlock) Encode(writer io.Writer) (n int, err error) {
 	var addressData []byte
 
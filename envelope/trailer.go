@@ -0,0 +1,229 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+)
+
+// Envelopes with a trailer index store blocks before the index, so they can be written
+// while blocks are produced. Every block is prefixed with its size, a zero size ends the blocks:
+//
+//	header (HasTrailerIndex, zero checksums)	26 bytes
+//	metadata section						when flagged in the header
+//	block size, block						4 bytes + block size, repeated
+//	zero block size							4 bytes
+//	index size								4 bytes
+//	index									index size
+//	index checksum							8 bytes
+//	blocks checksum							8 bytes
+//	index size								4 bytes
+//
+// The index size is repeated at the end, so readers with random access find the index from there.
+
+const trailerFooterSize int64 = 20
+
+// TrailerEncoder writes an envelope with a trailer index, one block at a time.
+//
+// The header is written with the first block, so the envelope must not allocate
+// more blocks than its address bytes can hold afterwards. Header.AddressBytes
+// can be raised upfront when the number of blocks is known to grow.
+type TrailerEncoder struct {
+	envelope        *Envelope
+	writer          io.Writer
+	blocksHash      hash.Hash64
+	entries         []encodeEntry
+	isWritten       map[block.BlockAddress]bool
+	metadataData    []byte
+	isHeaderWritten bool
+	isClosed        bool
+	addressBytes    int
+}
+
+func (envelope *Envelope) NewTrailerEncoder(writer io.Writer) *TrailerEncoder {
+	return &TrailerEncoder{
+		envelope:   envelope,
+		writer:     writer,
+		blocksHash: header.NewChecksumHash(),
+		isWritten:  map[block.BlockAddress]bool{},
+	}
+}
+
+// EncodeTrailer writes the envelope with a trailer index.
+func (envelope *Envelope) EncodeTrailer(writer io.Writer) (err error) {
+	var (
+		encoder *TrailerEncoder = envelope.NewTrailerEncoder(writer)
+		entries []encodeEntry
+	)
+
+	if entries, _, err = envelope.encodeEntries(); err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if err = encoder.WriteBlock(entry.block); err != nil {
+			return
+		}
+	}
+
+	return encoder.Close()
+}
+
+func (encoder *TrailerEncoder) writeHeader() (err error) {
+
+	if encoder.isHeaderWritten {
+		if encoder.envelope.Header.AddressBytes != encoder.addressBytes {
+			err = fmt.Errorf("address size grew to %d bytes after the header was written with %d bytes", encoder.envelope.Header.AddressBytes, encoder.addressBytes)
+		}
+
+		return
+	}
+
+	if encoder.envelope.Header.AddressBytes == 0 {
+		encoder.envelope.Header.AddressBytes = 1
+	}
+
+	encoder.envelope.Header.HasTrailerIndex = true
+	encoder.envelope.Header.HasMetadata = encoder.envelope.Metadata != nil
+	encoder.envelope.Header.IndexChecksum = header.Checksum{}
+	encoder.envelope.Header.BlocksChecksum = header.Checksum{}
+
+	if encoder.metadataData, err = encoder.envelope.encodeMetadata(); err != nil {
+		return
+	}
+
+	if _, err = encoder.envelope.Header.Encode(encoder.writer); err != nil {
+		return
+	}
+
+	encoder.envelope.Header.Version = header.CurrentVersion
+
+	if _, err = encoder.writer.Write(encoder.metadataData); err != nil {
+		return
+	}
+
+	encoder.isHeaderWritten = true
+	encoder.addressBytes = encoder.envelope.Header.AddressBytes
+
+	return
+}
+
+// WriteBlock writes an allocated block of the envelope. Keys and flags of the block
+// are stored in the index, so they can still be changed until Close.
+func (encoder *TrailerEncoder) WriteBlock(writtenBlock block.Block) (err error) {
+	var (
+		entry       encodeEntry = encodeEntry{block: writtenBlock}
+		hasIndex    bool
+		blockBuffer bytes.Buffer
+		blockSize   int
+		sizeBuffer  []byte = make([]byte, 4)
+	)
+
+	if encoder.isClosed {
+		err = fmt.Errorf("trailer encoder is closed")
+		return
+	}
+
+	if entry.blockIndex, hasIndex = encoder.envelope.Index.LookupBlockIndex(writtenBlock.Address()); !hasIndex {
+		err = fmt.Errorf("%w: block %d is not allocated in the envelope", block.ErrUnknownAddress, writtenBlock.Address())
+		return
+	}
+
+	if encoder.isWritten[writtenBlock.Address()] {
+		err = fmt.Errorf("block %d was already written", writtenBlock.Address())
+		return
+	}
+
+	if err = encoder.writeHeader(); err != nil {
+		return
+	}
+
+	if blockSize, err = writtenBlock.Encode(&blockBuffer); err != nil {
+		return
+	}
+
+	if int64(blockSize) >= 4294967296 {
+		err = fmt.Errorf("block exceeded maximum size of 4 GiB")
+		return
+	}
+
+	binary.LittleEndian.PutUint32(sizeBuffer, uint32(blockSize))
+
+	if _, err = encoder.writer.Write(sizeBuffer); err != nil {
+		return
+	}
+
+	if _, err = io.MultiWriter(encoder.writer, encoder.blocksHash).Write(blockBuffer.Bytes()); err != nil {
+		return
+	}
+
+	entry.blockIndex.BlockSize = uint32(blockSize)
+
+	encoder.entries = append(encoder.entries, entry)
+	encoder.isWritten[writtenBlock.Address()] = true
+
+	return
+}
+
+// Close writes the allocated blocks not written yet, the index and the checksums,
+// it does not close the underlying writer. Readers expect every allocated address indexed.
+func (encoder *TrailerEncoder) Close() (err error) {
+	var (
+		indexBuffer  bytes.Buffer
+		sizeBuffer   []byte = make([]byte, 4)
+		trailer      []byte
+		pendingBlock block.Block
+	)
+
+	if encoder.isClosed {
+		return
+	}
+
+	for _, address := range encoder.envelope.Index.AllocatedAddresses {
+		if encoder.isWritten[address] {
+			continue
+		}
+
+		if pendingBlock, err = encoder.envelope.allocatedBlock(address); err != nil {
+			return
+		}
+
+		if err = encoder.WriteBlock(pendingBlock); err != nil {
+			return
+		}
+	}
+
+	if err = encoder.writeHeader(); err != nil {
+		return
+	}
+
+	encoder.isClosed = true
+
+	if encoder.envelope.Header.IndexChecksum, err = encoder.envelope.encodeIndex(&indexBuffer, encoder.entries, encoder.metadataData); err != nil {
+		return
+	}
+
+	if int64(indexBuffer.Len()) >= 4294967296 {
+		err = fmt.Errorf("APO index exceeded maximum size of 4 GiB")
+		return
+	}
+
+	encoder.envelope.Header.BlocksChecksum = header.ChecksumFromHash(encoder.blocksHash)
+
+	binary.LittleEndian.PutUint32(sizeBuffer, uint32(indexBuffer.Len()))
+
+	trailer = append(trailer, 0x0, 0x0, 0x0, 0x0)
+	trailer = append(trailer, sizeBuffer...)
+	trailer = append(trailer, indexBuffer.Bytes()...)
+	trailer = append(trailer, encoder.envelope.Header.IndexChecksum.Value...)
+	trailer = append(trailer, encoder.envelope.Header.BlocksChecksum.Value...)
+	trailer = append(trailer, sizeBuffer...)
+
+	_, err = encoder.writer.Write(trailer)
+	return
+}
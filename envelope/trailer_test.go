@@ -0,0 +1,79 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTrailerRoundTrip(t *testing.T) {
+	var input map[string]interface{} = map[string]interface{}{
+		"a": "hello",
+		"b": []interface{}{1, 2.5, true},
+		"c": map[string]interface{}{"d": nil},
+	}
+
+	tests := []struct {
+		name   string
+		encode func(envelope *Envelope, buffer *bytes.Buffer) error
+	}{
+		{"encode trailer", func(envelope *Envelope, buffer *bytes.Buffer) error {
+			return envelope.EncodeTrailer(buffer)
+		}},
+		{"blocks in reverse", func(envelope *Envelope, buffer *bytes.Buffer) error {
+			var encoder *TrailerEncoder = envelope.NewTrailerEncoder(buffer)
+
+			for cursor := len(envelope.Index.AllocatedAddresses) - 1; cursor >= 0; cursor-- {
+				if err := encoder.WriteBlock(envelope.Blocks.Get(envelope.Index.AllocatedAddresses[cursor])); err != nil {
+					return err
+				}
+			}
+
+			return encoder.Close()
+		}},
+		{"blocks written by close", func(envelope *Envelope, buffer *bytes.Buffer) error {
+			var encoder *TrailerEncoder = envelope.NewTrailerEncoder(buffer)
+
+			if err := encoder.WriteBlock(envelope.Root()); err != nil {
+				return err
+			}
+
+			return encoder.Close()
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				envelope *Envelope = parseEnvelope(t, input)
+				expected string    = encodeJSONString(t, envelope)
+				buffer   bytes.Buffer
+				decoded  *Envelope = NewEnvelope()
+			)
+
+			if err := test.encode(envelope, &buffer); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := decoded.Decode(bytes.NewReader(buffer.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+
+			if !decoded.Header.HasTrailerIndex {
+				t.Fatal("expected a trailer index")
+			}
+
+			if actual := encodeJSONString(t, decoded); actual != expected {
+				t.Fatalf("decoded %s, expected %s", actual, expected)
+			}
+
+			reader, err := NewReaderAt(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := encodeJSONString(t, reader.Envelope); actual != expected {
+				t.Fatalf("read %s, expected %s", actual, expected)
+			}
+		})
+	}
+}
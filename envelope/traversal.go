@@ -0,0 +1,76 @@
+package envelope
+
+import (
+	"fmt"
+
+	"github.com/deitas/apo/block"
+)
+
+// traversal follows the object blocks below a block, as Walk, Interface, EncodeJSON and
+// ApplyExtensions do. It reports cyclic references and counts the blocks it expands against
+// MaxExpandedBlocks, since objects sharing a child expand it once per reference. MaxDepth
+// is enforced here as well, as envelopes read lazily cannot be checked upfront.
+type traversal struct {
+	visiting    map[*ObjectBlock]bool
+	expanded    int
+	maxExpanded int
+	maxDepth    int
+	// copies made by copyBlock by their source, so blocks shared by several objects are copied once
+	copies map[block.Block]block.Block
+}
+
+// newTraversal applies the smallest limits decoded with any of envelopes.
+func newTraversal(envelopes ...*Envelope) *traversal {
+	var current *traversal = &traversal{
+		visiting: map[*ObjectBlock]bool{},
+		copies:   map[block.Block]block.Block{},
+	}
+
+	for _, envelope := range envelopes {
+		current.maxExpanded = minLimit(current.maxExpanded, envelope.decodeOptions.MaxExpandedBlocks)
+		current.maxDepth = minLimit(current.maxDepth, envelope.decodeOptions.MaxDepth)
+	}
+
+	return current
+}
+
+// minLimit returns the smaller limit, zero disabling a limit.
+func minLimit(limit int, other int) int {
+	if limit == 0 || (other > 0 && other < limit) {
+		return other
+	}
+
+	return limit
+}
+
+// expand counts a block expanded by the traversal.
+func (current *traversal) expand() (err error) {
+	current.expanded++
+
+	if current.maxExpanded > 0 && current.expanded > current.maxExpanded {
+		err = fmt.Errorf("%w: more than %d blocks expanded", block.ErrLimitExceeded, current.maxExpanded)
+	}
+
+	return
+}
+
+// enter marks objectBlock as being expanded until leave, failing when it is reached again
+// below itself or nested deeper than MaxDepth object blocks.
+func (current *traversal) enter(objectBlock *ObjectBlock) (err error) {
+	if current.visiting[objectBlock] {
+		err = fmt.Errorf("cyclic reference to block with address %d", objectBlock.address)
+		return
+	}
+
+	if current.maxDepth > 0 && len(current.visiting) >= current.maxDepth {
+		err = objectBlock.envelope.depthError(objectBlock.address, current.maxDepth)
+		return
+	}
+
+	current.visiting[objectBlock] = true
+	return
+}
+
+func (current *traversal) leave(objectBlock *ObjectBlock) {
+	delete(current.visiting, objectBlock)
+}
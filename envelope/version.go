@@ -0,0 +1,90 @@
+package envelope
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/header"
+)
+
+// BlockDecoder decodes the data of a block as encoded by a particular format version.
+type BlockDecoder func(envelope *Envelope, address block.BlockAddress, blockType block.BlockType, buffer []byte) (block.Block, error)
+
+var (
+	versionDecoders      map[header.Version]BlockDecoder = map[header.Version]BlockDecoder{}
+	versionDecodersMutex sync.RWMutex
+)
+
+func init() {
+	// envelopes encoded before versions were stamped carry 0.0, they stored floats as text
+	RegisterVersion(header.Version{Major: 0, Minor: 0}, (*Envelope).decodeLegacyBlock)
+	// 1.1 added the metadata section, blocks are decoded like 1.0
+	RegisterVersion(header.Version{Major: 1, Minor: 0}, (*Envelope).decodeBlock)
+}
+
+// RegisterVersion sets the decoder of blocks encoded with version, replacing a registered one.
+// Decoders of older versions keep envelopes readable when the format changes.
+func RegisterVersion(version header.Version, decoder BlockDecoder) {
+	versionDecodersMutex.Lock()
+	defer versionDecodersMutex.Unlock()
+
+	versionDecoders[version] = decoder
+}
+
+// lookupVersionDecoder returns the decoder registered with the highest version of the same
+// major not above version, so newer minor versions are read like the latest known one.
+func lookupVersionDecoder(version header.Version) (decoder BlockDecoder, err error) {
+	var (
+		matchedVersion header.Version
+		isMatched      bool
+	)
+
+	versionDecodersMutex.RLock()
+	defer versionDecodersMutex.RUnlock()
+
+	for registeredVersion, registeredDecoder := range versionDecoders {
+		if registeredVersion.Major != version.Major || registeredVersion.Minor > version.Minor {
+			continue
+		}
+
+		if !isMatched || registeredVersion.Minor > matchedVersion.Minor {
+			matchedVersion = registeredVersion
+			decoder = registeredDecoder
+			isMatched = true
+		}
+	}
+
+	if !isMatched {
+		err = &block.DecodeError{
+			Offset:  8,
+			Section: block.SectionHeader,
+			Err:     fmt.Errorf("%w %s, current version is %s", block.ErrUnsupportedVersion, version, header.CurrentVersion),
+		}
+	}
+
+	return
+}
+
+// useVersion selects the block decoder of the decoded header version.
+func (envelope *Envelope) useVersion() (err error) {
+	envelope.versionDecoder, err = lookupVersionDecoder(envelope.Header.Version)
+	return
+}
+
+// decodeLegacyBlock decodes blocks of 0.0 envelopes, which share the layout of 1.0 except for floats.
+func (envelope *Envelope) decodeLegacyBlock(address block.BlockAddress, blockType block.BlockType, buffer []byte) (block.Block, error) {
+	if blockType == block.Float {
+		return envelope.decodeLegacyFloat(address, buffer)
+	}
+
+	return envelope.decodeBlock(address, blockType, buffer)
+}
+
+func (envelope *Envelope) decodeVersionBlock(address block.BlockAddress, blockType block.BlockType, buffer []byte) (block.Block, error) {
+	if envelope.versionDecoder == nil {
+		return envelope.decodeBlock(address, blockType, buffer)
+	}
+
+	return envelope.versionDecoder(envelope, address, blockType, buffer)
+}
@@ -0,0 +1,116 @@
+package apo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/envelope"
+)
+
+// Frames carry one encoded envelope each, prefixed with its size:
+//
+//	frame size		4 bytes
+//	envelope		frame size
+
+// FrameWriter writes envelopes as frames, it is safe for concurrent use.
+type FrameWriter struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+func NewFrameWriter(writer io.Writer) *FrameWriter {
+	return &FrameWriter{
+		writer: writer,
+	}
+}
+
+// WriteEnvelope encodes the envelope and writes it as a single frame.
+func (frameWriter *FrameWriter) WriteEnvelope(_envelope *envelope.Envelope) (err error) {
+	var data []byte
+
+	if data, err = _envelope.Marshal(); err != nil {
+		return
+	}
+
+	return frameWriter.WriteFrame(data)
+}
+
+// WriteFrame writes data, which should hold an encoded envelope, as a single frame.
+func (frameWriter *FrameWriter) WriteFrame(data []byte) (err error) {
+	var frame []byte = make([]byte, 4, 4+len(data))
+
+	if int64(len(data)) >= 4294967296 {
+		err = fmt.Errorf("frame exceeded maximum size of 4 GiB")
+		return
+	}
+
+	binary.LittleEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+
+	frameWriter.mutex.Lock()
+	defer frameWriter.mutex.Unlock()
+
+	_, err = frameWriter.writer.Write(frame)
+	return
+}
+
+// FrameReader reads envelopes written by FrameWriter one by one. Once ReadFrame
+// fails the position in the stream is lost, so no further frames can be read.
+type FrameReader struct {
+	reader       io.Reader
+	maxFrameSize uint32
+	options      []envelope.DecodeOptions
+}
+
+// NewFrameReader reads frames of at most maxFrameSize bytes, zero disables the limit.
+// Options are used to decode envelopes of frames.
+func NewFrameReader(reader io.Reader, maxFrameSize uint32, options ...envelope.DecodeOptions) *FrameReader {
+	return &FrameReader{
+		reader:       reader,
+		maxFrameSize: maxFrameSize,
+		options:      options,
+	}
+}
+
+// ReadFrame returns the data of the following frame, io.EOF when the reader ends between frames.
+func (frameReader *FrameReader) ReadFrame() (data []byte, err error) {
+	var (
+		sizeData  []byte = make([]byte, 4)
+		frameSize uint32
+		buffer    bytes.Buffer
+	)
+
+	if _, err = io.ReadFull(frameReader.reader, sizeData); err != nil {
+		return
+	}
+
+	frameSize = binary.LittleEndian.Uint32(sizeData)
+
+	if frameReader.maxFrameSize > 0 && frameSize > frameReader.maxFrameSize {
+		err = fmt.Errorf("%w: frame of %d bytes is larger than %d bytes", block.ErrLimitExceeded, frameSize, frameReader.maxFrameSize)
+		return
+	}
+
+	// the buffer grows with the data actually received, so a forged size cannot allocate memory upfront
+	if _, err = io.CopyN(&buffer, frameReader.reader, int64(frameSize)); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	data = buffer.Bytes()
+	return
+}
+
+// ReadEnvelope decodes the envelope of the following frame, io.EOF when the reader ends between frames.
+func (frameReader *FrameReader) ReadEnvelope() (_envelope *envelope.Envelope, err error) {
+	var data []byte
+
+	if data, err = frameReader.ReadFrame(); err != nil {
+		return
+	}
+
+	return Read(bytes.NewReader(data), frameReader.options...)
+}
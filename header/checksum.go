@@ -3,18 +3,43 @@ package header
 import (
 	"bytes"
 	"encoding/binary"
+	"hash"
 	"hash/crc64"
 )
 
+var checksumTable *crc64.Table = crc64.MakeTable(crc64.ECMA)
+
 type Checksum struct {
 	Value   []byte
 	IsValid bool
 }
 
+// bytes returns the 8 bytes of the checksum, zeros when it was not calculated yet.
+func (checksum Checksum) bytes() []byte {
+	if len(checksum.Value) != 8 {
+		return make([]byte, 8)
+	}
+
+	return checksum.Value
+}
+
 func CalcuateChecksum(buffer *bytes.Buffer) Checksum {
+	var checksumHash hash.Hash64 = NewChecksumHash()
+
+	checksumHash.Write(buffer.Bytes())
+
+	return ChecksumFromHash(checksumHash)
+}
+
+// NewChecksumHash returns a hash computing checksums incrementally, see ChecksumFromHash.
+func NewChecksumHash() hash.Hash64 {
+	return crc64.New(checksumTable)
+}
+
+func ChecksumFromHash(checksumHash hash.Hash64) Checksum {
 	var checksumBuffer []byte = make([]byte, 8)
 
-	binary.LittleEndian.PutUint64(checksumBuffer, crc64.Checksum(buffer.Bytes(), crc64.MakeTable(crc64.ECMA)))
+	binary.LittleEndian.PutUint64(checksumBuffer, checksumHash.Sum64())
 
 	return Checksum{
 		Value:   checksumBuffer,
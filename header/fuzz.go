@@ -0,0 +1,17 @@
+//go:build gofuzz
+// +build gofuzz
+
+package header
+
+// Fuzz is the entry point for go-fuzz, seeded from testdata/fuzz/corpus:
+//
+//	go-fuzz-build && go-fuzz -workdir=testdata/fuzz
+func Fuzz(data []byte) int {
+	var header *Header = NewHeader()
+
+	if err := header.Decode(data); err != nil {
+		return 0
+	}
+
+	return 1
+}
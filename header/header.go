@@ -3,12 +3,18 @@ package header
 import (
 	"fmt"
 	"io"
+
+	"github.com/deitas/apo/block"
 )
 
 const (
 	fileSignature                string = "\x89\x41\x50\x4f\x0d\x0a\x1a\x0a"
+	hasMetadataFlag              byte   = 0x80
+	addressBytesMask             byte   = 0x70
 	isExtensionFlag              byte   = 0x8
 	enableMemoryOptimizationFlag byte   = 0x4
+	hasTrailerIndexFlag          byte   = 0x2
+	hasJournalFlag               byte   = 0x1
 )
 
 type Header struct {
@@ -17,31 +23,46 @@ type Header struct {
 	BlocksChecksum           Checksum
 	IsExtension              bool
 	EnableMemoryOptimization bool
+	HasTrailerIndex          bool
+	HasJournal               bool
+	HasMetadata              bool
 	AddressBytes             int
 }
 
 func NewHeader() *Header {
-	return &Header{}
+	return &Header{
+		Version: CurrentVersion,
+	}
 }
 
 // Version:						8 bits		1 byte
 
+// HasMetadata:					1 bit		|
 // AddressBytes:				3 bits		|
 // IsExtension:					1 bit		| 1 byte
 // EnableMemoryOptimization:	1 bit		|
+// HasTrailerIndex:				1 bit		|
+// HasJournal:					1 bit		|
 
-// IndexChecksum:				64 bits		8 bytes
+// IndexChecksum:				64 bits		8 bytes, covering the metadata section and the index
 // BlocksChecksum:				64 bits		8 bytes
 
+// Encode writes the header stamped with CurrentVersion, the version of header is kept.
 func (header *Header) Encode(writer io.Writer) (int, error) {
 	var (
-		flags byte
-		data  []byte = []byte(fileSignature)
+		flags        byte
+		data         []byte = []byte(fileSignature)
+		addressBytes int    = header.AddressBytes
 	)
 
-	data = append(data, header.Version.ToByte())
+	data = append(data, CurrentVersion.ToByte())
 
-	flags = byte(header.AddressBytes-1) << 4
+	// an envelope without blocks has no address size yet
+	if addressBytes < 1 {
+		addressBytes = 1
+	}
+
+	flags = byte(addressBytes-1) << 4
 
 	if header.IsExtension {
 		flags = flags | isExtensionFlag
@@ -51,32 +72,76 @@ func (header *Header) Encode(writer io.Writer) (int, error) {
 		flags = flags | enableMemoryOptimizationFlag
 	}
 
+	if header.HasTrailerIndex {
+		flags = flags | hasTrailerIndexFlag
+	}
+
+	if header.HasJournal {
+		flags = flags | hasJournalFlag
+	}
+
+	if header.HasMetadata {
+		flags = flags | hasMetadataFlag
+	}
+
 	data = append(data, flags)
 
-	data = append(data, header.IndexChecksum.Value...)
-	data = append(data, header.BlocksChecksum.Value...)
+	data = append(data, header.IndexChecksum.bytes()...)
+	data = append(data, header.BlocksChecksum.bytes()...)
 
 	return writer.Write(data)
 }
 
+// Decode reads a header, refusing versions of an unknown major and flags unknown to the version.
 func (header *Header) Decode(data []byte) (err error) {
+	var knownFlags byte
+
 	if len(data) < 26 {
-		err = fmt.Errorf("APO header is too short")
+		err = &block.DecodeError{
+			Offset:  int64(len(data)),
+			Section: block.SectionHeader,
+			Err:     block.ErrTruncated,
+		}
+		return
 	}
 
 	if string(data[0:8]) != fileSignature {
-		err = fmt.Errorf("not APO file")
+		err = &block.DecodeError{
+			Section: block.SectionHeader,
+			Err:     block.ErrNotAPO,
+		}
 		return
 	}
 
 	header.Version.decode(data[8])
 
+	if knownFlags, err = header.Version.knownFlags(); err != nil {
+		err = &block.DecodeError{
+			Offset:  8,
+			Section: block.SectionHeader,
+			Err:     err,
+		}
+		return
+	}
+
 	flags := data[9]
 
-	header.AddressBytes = int(flags>>4) + 1
+	if unknownFlags := flags &^ (knownFlags | addressBytesMask); unknownFlags != 0 {
+		err = &block.DecodeError{
+			Offset:  9,
+			Section: block.SectionHeader,
+			Err:     fmt.Errorf("%w: flags %#x are unknown to version %s", block.ErrUnsupportedVersion, unknownFlags, header.Version),
+		}
+		return
+	}
+
+	header.AddressBytes = int((flags&addressBytesMask)>>4) + 1
 
 	header.IsExtension = (flags & isExtensionFlag) == isExtensionFlag
 	header.EnableMemoryOptimization = (flags & enableMemoryOptimizationFlag) == enableMemoryOptimizationFlag
+	header.HasTrailerIndex = (flags & hasTrailerIndexFlag) == hasTrailerIndexFlag
+	header.HasJournal = (flags & hasJournalFlag) == hasJournalFlag
+	header.HasMetadata = (flags & hasMetadataFlag) == hasMetadataFlag
 
 	header.IndexChecksum = Checksum{Value: data[10:18]}
 	header.BlocksChecksum = Checksum{Value: data[18:26]}
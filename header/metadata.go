@@ -0,0 +1,177 @@
+package header
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/deitas/apo/block"
+)
+
+// Headers flagged with metadata are followed by a section of tagged entries:
+//
+//	metadata size		4 bytes
+//	entries				metadata size
+//
+// Every entry holds a tag (1 byte), the size of its value (4 bytes) and the value.
+// Readers skip entries with unknown tags, they are kept and encoded again.
+
+const (
+	MetadataCreatedAt   byte = 0x1
+	MetadataProducer    byte = 0x2
+	MetadataContentType byte = 0x3
+	MetadataSchemaID    byte = 0x4
+	MetadataAttribute   byte = 0x5
+
+	metadataOffset int64 = 30
+)
+
+type Metadata struct {
+	CreatedAt   time.Time
+	Producer    string
+	ContentType string
+	SchemaID    string
+	// Attributes are encoded in the order of their keys, each one as a separate entry.
+	Attributes map[string]string
+
+	unknown []metadataEntry
+}
+
+type metadataEntry struct {
+	tag   byte
+	value []byte
+}
+
+func (metadata *Metadata) entries() (entries []metadataEntry) {
+	var keys []string
+
+	if !metadata.CreatedAt.IsZero() {
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, uint64(metadata.CreatedAt.UnixNano()))
+		entries = append(entries, metadataEntry{tag: MetadataCreatedAt, value: value})
+	}
+
+	for _, entry := range []metadataEntry{
+		{tag: MetadataProducer, value: []byte(metadata.Producer)},
+		{tag: MetadataContentType, value: []byte(metadata.ContentType)},
+		{tag: MetadataSchemaID, value: []byte(metadata.SchemaID)},
+	} {
+		if len(entry.value) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	for key := range metadata.Attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := make([]byte, 4, 4+len(key)+len(metadata.Attributes[key]))
+		binary.LittleEndian.PutUint32(value, uint32(len(key)))
+		value = append(append(value, key...), metadata.Attributes[key]...)
+		entries = append(entries, metadataEntry{tag: MetadataAttribute, value: value})
+	}
+
+	return append(entries, metadata.unknown...)
+}
+
+// Encode writes the metadata section following the header.
+func (metadata *Metadata) Encode(writer io.Writer) (int, error) {
+	var data []byte = make([]byte, 4)
+
+	for _, entry := range metadata.entries() {
+		var entryHeader []byte = make([]byte, 5)
+
+		entryHeader[0] = entry.tag
+		binary.LittleEndian.PutUint32(entryHeader[1:5], uint32(len(entry.value)))
+
+		data = append(data, entryHeader...)
+		data = append(data, entry.value...)
+	}
+
+	if int64(len(data)-4) >= 4294967296 {
+		return 0, fmt.Errorf("metadata exceeded maximum size of 4 GiB")
+	}
+
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(data)-4))
+
+	return writer.Write(data)
+}
+
+// DecodeMetadata decodes the entries of the metadata section, without its size.
+func DecodeMetadata(data []byte) (metadata *Metadata, err error) {
+	var cursor int
+
+	metadata = &Metadata{}
+
+	for cursor < len(data) {
+		var (
+			tag       byte
+			valueSize int
+			value     []byte
+		)
+
+		if len(data)-cursor < 5 {
+			return nil, metadataError(cursor, block.ErrTruncated)
+		}
+
+		tag = data[cursor]
+		valueSize = int(binary.LittleEndian.Uint32(data[cursor+1 : cursor+5]))
+		cursor += 5
+
+		if valueSize > len(data)-cursor {
+			return nil, metadataError(cursor-4, fmt.Errorf("%w: metadata entry of %d bytes", block.ErrTruncated, valueSize))
+		}
+
+		value = data[cursor : cursor+valueSize]
+
+		switch tag {
+		case MetadataCreatedAt:
+			if valueSize != 8 {
+				return nil, metadataError(cursor, fmt.Errorf("%w: creation time of %d bytes", block.ErrInvalidSize, valueSize))
+			}
+
+			metadata.CreatedAt = time.Unix(0, int64(binary.LittleEndian.Uint64(value)))
+		case MetadataProducer:
+			metadata.Producer = string(value)
+		case MetadataContentType:
+			metadata.ContentType = string(value)
+		case MetadataSchemaID:
+			metadata.SchemaID = string(value)
+		case MetadataAttribute:
+			var keySize int
+
+			if valueSize < 4 {
+				return nil, metadataError(cursor, fmt.Errorf("%w: attribute of %d bytes", block.ErrInvalidSize, valueSize))
+			}
+
+			if keySize = int(binary.LittleEndian.Uint32(value[0:4])); keySize > valueSize-4 {
+				return nil, metadataError(cursor, fmt.Errorf("%w: attribute key of %d bytes", block.ErrInvalidSize, keySize))
+			}
+
+			if metadata.Attributes == nil {
+				metadata.Attributes = map[string]string{}
+			}
+
+			metadata.Attributes[string(value[4:4+keySize])] = string(value[4+keySize:])
+		default:
+			metadata.unknown = append(metadata.unknown, metadataEntry{tag: tag, value: append([]byte{}, value...)})
+		}
+
+		cursor += valueSize
+	}
+
+	return
+}
+
+func metadataError(cursor int, err error) error {
+	return &block.DecodeError{
+		Offset:  metadataOffset + int64(cursor),
+		Section: block.SectionHeader,
+		Err:     err,
+	}
+}
@@ -2,22 +2,67 @@ package header
 
 import (
 	"fmt"
+
+	"github.com/deitas/apo/block"
 )
 
+// CurrentVersion is the format version stamped on encoded headers. Minor versions
+// only add to the format, so readers can adapt to newer minor versions of their major.
+var CurrentVersion = Version{Major: 1, Minor: 1}
+
+// versionFlags holds the header flags known to each version, the address size bits are
+// known to all of them. Headers are decoded with the highest version of their major not
+// above their own, flags unknown to it are refused.
+var versionFlags = map[Version]byte{
+	{Major: 0, Minor: 0}: isExtensionFlag | enableMemoryOptimizationFlag,
+	{Major: 1, Minor: 0}: isExtensionFlag | enableMemoryOptimizationFlag | hasTrailerIndexFlag | hasJournalFlag,
+	{Major: 1, Minor: 1}: isExtensionFlag | enableMemoryOptimizationFlag | hasTrailerIndexFlag | hasJournalFlag | hasMetadataFlag,
+}
+
 type Version struct {
 	Major int
 	Minor int
 }
 
 func (version Version) ToString() string {
-	return fmt.Sprintf("%d.%d", version.Major, version.Major)
+	return fmt.Sprintf("%d.%d", version.Major, version.Minor)
+}
+
+func (version Version) String() string {
+	return version.ToString()
 }
 
 func (version Version) ToByte() byte {
 	return (byte(version.Major) << 4) | byte(version.Minor)
 }
 
-func (version Version) decode(versionByte byte) {
+func (version *Version) decode(versionByte byte) {
 	version.Major = int(versionByte >> 4)
 	version.Minor = int((versionByte << 4) >> 4)
 }
+
+// knownFlags returns the header flags known to version.
+func (version Version) knownFlags() (flags byte, err error) {
+	var (
+		matchedVersion Version
+		isMatched      bool
+	)
+
+	for knownVersion, knownFlags := range versionFlags {
+		if knownVersion.Major != version.Major || knownVersion.Minor > version.Minor {
+			continue
+		}
+
+		if !isMatched || knownVersion.Minor > matchedVersion.Minor {
+			matchedVersion = knownVersion
+			flags = knownFlags
+			isMatched = true
+		}
+	}
+
+	if !isMatched {
+		err = fmt.Errorf("%w %s, current version is %s", block.ErrUnsupportedVersion, version, CurrentVersion)
+	}
+
+	return
+}
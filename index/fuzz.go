@@ -0,0 +1,36 @@
+//go:build gofuzz
+// +build gofuzz
+
+package index
+
+import (
+	"github.com/deitas/apo/header"
+)
+
+// Fuzz is the entry point for go-fuzz, seeded from testdata/fuzz/corpus:
+//
+//	go-fuzz-build && go-fuzz -workdir=testdata/fuzz
+func Fuzz(data []byte) int {
+	var (
+		_header *header.Header = header.NewHeader()
+		index   *Index         = NewIndex()
+	)
+
+	if err := _header.Decode(data); err != nil {
+		return -1
+	}
+
+	if _, err := index.Decode(_header, data); err != nil {
+		return 0
+	}
+
+	for _, address := range index.AllocatedAddresses {
+		if blockIndex, hasBlockIndex := index.LookupBlockIndex(address); hasBlockIndex {
+			if _, err := blockIndex.ToBytes(_header); err != nil {
+				return 0
+			}
+		}
+	}
+
+	return 1
+}
@@ -90,10 +90,18 @@ func (blockIndex *BlockIndex) ToBytes(header *header.Header) (data []byte, err e
 		var (
 			keyBuffer   []byte
 			unsignedKey uint
-			key         int  = blockIndex.Key.(int)
-			isNegative  bool = key < 0
+			key         int
+			isInt       bool
+			isNegative  bool
 		)
 
+		if key, isInt = blockIndex.Key.(int); !isInt {
+			err = fmt.Errorf("invalid int key type: %T", blockIndex.Key)
+			return
+		}
+
+		isNegative = key < 0
+
 		if isNegative {
 			unsignedKey = uint(key * -1)
 		} else {
@@ -136,45 +144,32 @@ func (blockIndex *BlockIndex) ToBytes(header *header.Header) (data []byte, err e
 }
 
 func (blockIndex *BlockIndex) decode(header *header.Header, data []byte) (err error) {
+	if len(data) < header.AddressBytes+5 {
+		err = fmt.Errorf("%w: block index must be at least %d bytes, got %d", block.ErrInvalidSize, header.AddressBytes+5, len(data))
+		return
+	}
+
 	blockIndex.bitmask = data[0]
 	blockIndex.Type = block.ParseBlockTypeBitmask(blockIndex.bitmask)
 
-	var addressBuffer []byte
-	switch header.AddressBytes {
-	case 1:
-		addressBuffer = []byte{data[1], 0x0}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-	case 2:
-		addressBuffer = []byte{data[1], data[2]}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint16(addressBuffer))
-	case 3:
-		addressBuffer = []byte{data[1], data[2], data[3], 0x0}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-	case 4:
-		addressBuffer = []byte{data[1], data[2], data[3], data[4]}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint32(addressBuffer))
-	case 5:
-		addressBuffer = []byte{data[1], data[2], data[3], data[4], data[5], 0x0, 0x0, 0x0}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	case 6:
-		addressBuffer = []byte{data[1], data[2], data[3], data[4], data[5], data[6], 0x0, 0x0}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	case 7:
-		addressBuffer = []byte{data[1], data[2], data[3], data[4], data[5], data[6], data[7], 0x0}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
-	case 8:
-		addressBuffer = []byte{data[1], data[2], data[3], data[4], data[5], data[6], data[7], data[8]}
-		blockIndex.address = block.BlockAddress(binary.LittleEndian.Uint64(addressBuffer))
+	if blockIndex.address, err = block.DecodeBlockAddress(data[1:], header.AddressBytes); err != nil {
+		return
 	}
 
 	blockIndex.BlockSize = binary.LittleEndian.Uint32(data[header.AddressBytes+1 : header.AddressBytes+5])
 
+	if blockIndex.HasFlag(BitmaskIntKey) && len(data) == header.AddressBytes+5 {
+		err = fmt.Errorf("%w: int key is missing", block.ErrInvalidKey)
+		return
+	}
+
 	if len(data) > header.AddressBytes+5 {
 		keyBuffer := data[header.AddressBytes+5:]
 
 		if blockIndex.HasFlag(BitmaskIntKey) {
 			var isNegative bool = (keyBuffer[len(keyBuffer)-1] & bitmaskNegative) == bitmaskNegative
 
+			keyBuffer = append([]byte{}, keyBuffer...)
 			keyBuffer[len(keyBuffer)-1] = keyBuffer[len(keyBuffer)-1] & ^bitmaskNegative
 
 			switch len(keyBuffer) {
@@ -183,7 +178,7 @@ func (blockIndex *BlockIndex) decode(header *header.Header, data []byte) (err er
 			case 8:
 				blockIndex.Key = int(binary.LittleEndian.Uint64(keyBuffer))
 			default:
-				err = fmt.Errorf("invalid int key size")
+				err = fmt.Errorf("%w: int key must be 4 or 8 bytes, got %d", block.ErrInvalidKey, len(keyBuffer))
 				return
 			}
 
@@ -200,6 +195,32 @@ func (blockIndex *BlockIndex) decode(header *header.Header, data []byte) (err er
 	return
 }
 
+// Limits bound the resources used by Decode, zero values disable a limit.
+type Limits struct {
+	MaxBlocks    int
+	MaxBlockSize uint32
+	MaxKeyLength int
+}
+
+func (limits Limits) check(blockIndex *BlockIndex, blocksCount int) (err error) {
+	if limits.MaxBlocks > 0 && blocksCount > limits.MaxBlocks {
+		err = fmt.Errorf("%w: more than %d blocks", block.ErrLimitExceeded, limits.MaxBlocks)
+		return
+	}
+
+	if limits.MaxBlockSize > 0 && blockIndex.BlockSize > limits.MaxBlockSize {
+		err = fmt.Errorf("%w: block size %d is larger than %d", block.ErrLimitExceeded, blockIndex.BlockSize, limits.MaxBlockSize)
+		return
+	}
+
+	if key, isString := blockIndex.Key.(string); isString && limits.MaxKeyLength > 0 && len(key) > limits.MaxKeyLength {
+		err = fmt.Errorf("%w: key length %d is longer than %d", block.ErrLimitExceeded, len(key), limits.MaxKeyLength)
+		return
+	}
+
+	return
+}
+
 type Index struct {
 	AllocatedAddresses []block.BlockAddress
 	Blocks             map[block.BlockAddress]*BlockIndex
@@ -297,15 +318,116 @@ func (index *Index) DisableFlag(address block.BlockAddress, flag Flag) {
 	}
 }
 
-func (index *Index) Decode(header *header.Header, data []byte) (cursor uint32, err error) {
-	indexSize := binary.LittleEndian.Uint32(data[26:30])
+func (index *Index) Decode(header *header.Header, data []byte, limits ...Limits) (cursor uint32, err error) {
+	var (
+		indexSize  uint32
+		sizeOffset uint64 = 26
+	)
+
+	// the index size follows the metadata section of headers flagged with metadata
+	if header.HasMetadata && len(data) >= 30 {
+		sizeOffset = 30 + uint64(binary.LittleEndian.Uint32(data[26:30]))
+	}
+
+	if uint64(len(data)) < sizeOffset+4 {
+		err = &block.DecodeError{
+			Offset:  int64(len(data)),
+			Section: block.SectionIndex,
+			Err:     block.ErrTruncated,
+		}
+		return
+	}
+
+	indexSize = binary.LittleEndian.Uint32(data[sizeOffset : sizeOffset+4])
+
+	if uint64(len(data)) < sizeOffset+4+uint64(indexSize) {
+		err = &block.DecodeError{
+			Offset:  int64(sizeOffset),
+			Section: block.SectionIndex,
+			Err:     fmt.Errorf("%w: index of %d bytes exceeds data", block.ErrTruncated, indexSize),
+		}
+		return
+	}
+
+	if err = index.DecodeEntries(header, data[sizeOffset+4:sizeOffset+4+uint64(indexSize)], int64(sizeOffset+4), limits...); err != nil {
+		return
+	}
+
+	if err = index.CheckAddresses(); err != nil {
+		return
+	}
+
+	cursor = uint32(sizeOffset + 4 + uint64(indexSize))
+	return
+}
+
+// DecodeEntries decodes the block indexes of an index section without its size prefix,
+// offset is the position of data within the APO data reported by errors. Addresses indexed
+// twice are rejected, CheckAddresses verifies the addresses of a complete index.
+func (index *Index) DecodeEntries(header *header.Header, data []byte, offset int64, limits ...Limits) (err error) {
+	var (
+		cursor      uint32
+		indexEnd    uint32 = uint32(len(data))
+		blockLimits Limits
+	)
+
+	if len(limits) > 0 {
+		blockLimits = limits[0]
+	}
+
+	for cursor < indexEnd {
+		if indexEnd-cursor < 2 {
+			err = &block.DecodeError{
+				Offset:  offset + int64(cursor),
+				Section: block.SectionIndex,
+				Err:     block.ErrTruncated,
+			}
+			return
+		}
 
-	cursor = 30
-	for cursor < 30+indexSize {
 		blockIndexSize := uint32(binary.LittleEndian.Uint16(data[cursor : cursor+2]))
 
+		if indexEnd-cursor-2 < blockIndexSize {
+			err = &block.DecodeError{
+				Offset:  offset + int64(cursor),
+				Section: block.SectionIndex,
+				Err:     fmt.Errorf("%w: block index of %d bytes exceeds index", block.ErrTruncated, blockIndexSize),
+			}
+			return
+		}
+
 		blockIndex := &BlockIndex{}
 		if err = blockIndex.decode(header, data[cursor+2:cursor+blockIndexSize+2]); err != nil {
+			err = &block.DecodeError{
+				Offset:    offset + int64(cursor),
+				Section:   block.SectionIndex,
+				Address:   blockIndex.address,
+				BlockType: blockIndex.Type,
+				Err:       err,
+			}
+			return
+		}
+
+		if _, isIndexed := index.Blocks[blockIndex.address]; isIndexed || blockIndex.address == 0 {
+			err = &block.DecodeError{
+				Offset:    offset + int64(cursor),
+				Section:   block.SectionIndex,
+				Address:   blockIndex.address,
+				BlockType: blockIndex.Type,
+				Err:       fmt.Errorf("%w: address %d is indexed twice or zero", block.ErrUnknownAddress, blockIndex.address),
+			}
+			return
+		}
+
+		if err = blockLimits.check(blockIndex, len(index.AllocatedAddresses)+1); err != nil {
+			err = &block.DecodeError{
+				Offset:    offset + int64(cursor),
+				Section:   block.SectionIndex,
+				Address:   blockIndex.address,
+				BlockType: blockIndex.Type,
+				Key:       blockIndex.Key,
+				Err:       err,
+			}
 			return
 		}
 
@@ -317,3 +439,41 @@ func (index *Index) Decode(header *header.Header, data []byte) (cursor uint32, e
 
 	return
 }
+
+// CheckAddresses verifies that the addresses of the index are 1 to the number of its block
+// indexes in any order, so AllocateAddress continues them without colliding.
+func (index *Index) CheckAddresses() (err error) {
+	for _, address := range index.AllocatedAddresses {
+		if address == 0 || int(address) > len(index.AllocatedAddresses) {
+			err = &block.DecodeError{
+				Section:   block.SectionIndex,
+				Address:   address,
+				BlockType: index.Blocks[address].Type,
+				Key:       index.Blocks[address].Key,
+				Err:       fmt.Errorf("%w: address %d exceeds the %d indexed blocks", block.ErrUnknownAddress, address, len(index.AllocatedAddresses)),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+// Merge applies the entries of delta, replacing entries with the same address. Addresses
+// not yet in the index must continue the allocated addresses, so allocation stays consistent.
+func (index *Index) Merge(delta *Index) (err error) {
+	for _, address := range delta.AllocatedAddresses {
+		if _, hasBlockIndex := index.Blocks[address]; !hasBlockIndex {
+			if address != block.BlockAddress(len(index.AllocatedAddresses)+1) {
+				err = fmt.Errorf("%w: address %d does not follow %d allocated addresses", block.ErrUnknownAddress, address, len(index.AllocatedAddresses))
+				return
+			}
+
+			index.AllocatedAddresses = append(index.AllocatedAddresses, address)
+		}
+
+		index.Blocks[address] = delta.Blocks[address]
+	}
+
+	return
+}
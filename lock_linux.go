@@ -0,0 +1,39 @@
+package apo
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on the file name, waiting for other holders.
+func lockFile(name string, perm os.FileMode) (unlock func() error, err error) {
+	var file *os.File
+
+	if file, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm); err != nil {
+		return
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return
+	}
+
+	unlock = func() error {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		return file.Close()
+	}
+
+	return
+}
+
+// syncDir flushes the directory entries, so a renamed file survives a crash.
+func syncDir(dir string) (err error) {
+	var file *os.File
+
+	if file, err = os.Open(dir); err != nil {
+		return
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package apo
+
+import (
+	"os"
+)
+
+// lockFile does not lock where advisory locks are not supported.
+func lockFile(name string, perm os.FileMode) (unlock func() error, err error) {
+	return func() error { return nil }, nil
+}
+
+// syncDir does nothing where directories cannot be synced.
+func syncDir(dir string) error {
+	return nil
+}
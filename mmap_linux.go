@@ -0,0 +1,64 @@
+package apo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+type mappedFile struct {
+	file *os.File
+	data []byte
+}
+
+func mapFile(file *os.File, size int64) (*mappedFile, error) {
+	var (
+		err  error
+		data []byte
+	)
+
+	if size > 0 {
+		if data, err = syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
+			return nil, err
+		}
+	}
+
+	return &mappedFile{
+		file: file,
+		data: data,
+	}, nil
+}
+
+func (mappedFile *mappedFile) ReadAt(data []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset: %d", offset)
+	}
+
+	if offset >= int64(len(mappedFile.data)) {
+		return 0, io.EOF
+	}
+
+	if n = copy(data, mappedFile.data[offset:]); n < len(data) {
+		err = io.EOF
+	}
+
+	return
+}
+
+func (mappedFile *mappedFile) Bytes() []byte {
+	return mappedFile.data
+}
+
+func (mappedFile *mappedFile) Close() (err error) {
+	if mappedFile.data != nil {
+		err = syscall.Munmap(mappedFile.data)
+		mappedFile.data = nil
+	}
+
+	if closeErr := mappedFile.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package apo
+
+import (
+	"os"
+)
+
+// mappedFile falls back to reading the file where memory mapping is not supported.
+type mappedFile struct {
+	*os.File
+}
+
+func mapFile(file *os.File, size int64) (*mappedFile, error) {
+	return &mappedFile{File: file}, nil
+}
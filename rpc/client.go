@@ -0,0 +1,131 @@
+package rpc
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/envelope"
+)
+
+// Client calls methods of a server over a connection, one batch of calls at a time.
+type Client struct {
+	conn        net.Conn
+	frameReader *apo.FrameReader
+	frameWriter *apo.FrameWriter
+	mutex       sync.Mutex
+	lastID      int64
+}
+
+// Call is a single call of a batch. Result and Err are set when the batch completes,
+// failures of the handler are reported as *Error.
+type Call struct {
+	Method string
+	Args   interface{}
+	Result interface{}
+	Err    error
+}
+
+func NewClient(conn net.Conn, options ...Options) (client *Client) {
+	var clientOptions Options = DefaultOptions
+
+	if len(options) > 0 {
+		clientOptions = options[0]
+	}
+
+	return &Client{
+		conn:        conn,
+		frameReader: apo.NewFrameReader(conn, clientOptions.MaxFrameSize, clientOptions.decodeOptions()),
+		frameWriter: apo.NewFrameWriter(conn),
+	}
+}
+
+// Dial connects to the server at address, see net.Dial.
+func Dial(network string, address string, options ...Options) (client *Client, err error) {
+	var conn net.Conn
+
+	if conn, err = net.Dial(network, address); err != nil {
+		return
+	}
+
+	return NewClient(conn, options...), nil
+}
+
+// Call sends a request for method with args, parsed with envelope.ParseBlock, and returns
+// the result of the handler. Failures of the handler are returned as *Error.
+func (client *Client) Call(method string, args interface{}) (result interface{}, err error) {
+	var call *Call = &Call{Method: method, Args: args}
+
+	if err = client.Batch(call); err != nil {
+		return
+	}
+
+	return call.Result, call.Err
+}
+
+// Batch sends the requests of all calls in one envelope and waits for their responses.
+// The returned error reports failures of the connection, results are set on the calls.
+// Nothing is sent without calls.
+func (client *Client) Batch(calls ...*Call) (err error) {
+	var (
+		request       *envelope.Envelope = envelope.NewEnvelope()
+		requestBlocks []*envelope.ObjectBlock
+		requestBlock  *envelope.ObjectBlock
+		response      *envelope.Envelope
+		responses     envelope.ResponseMap
+	)
+
+	if len(calls) == 0 {
+		return
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	for _, call := range calls {
+		client.lastID++
+
+		if requestBlock, err = request.AddRequest(client.lastID, call.Method, call.Args); err != nil {
+			return
+		}
+
+		requestBlocks = append(requestBlocks, requestBlock)
+	}
+
+	if err = client.frameWriter.WriteEnvelope(request); err != nil {
+		return
+	}
+
+	if response, err = client.frameReader.ReadEnvelope(); err != nil {
+		return
+	}
+
+	responses = response.ResponsesByID()
+
+	for cursor, call := range calls {
+		var (
+			responseBlock *envelope.ObjectBlock
+			hasResponse   bool
+			message       string
+		)
+
+		if responseBlock, hasResponse = responses.For(requestBlocks[cursor]); !hasResponse {
+			call.Err = fmt.Errorf("missing response to %s", call.Method)
+			continue
+		}
+
+		if message, call.Err = lookupString(responseBlock, ErrorKey); call.Err == nil {
+			call.Err = &Error{Method: call.Method, Message: message}
+			continue
+		}
+
+		call.Result, call.Err = lookupInterface(responseBlock, ResultKey)
+	}
+
+	return
+}
+
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
@@ -0,0 +1,90 @@
+// Package rpc calls Go handlers over any net.Conn, exchanging envelopes framed by apo.FrameWriter.
+//
+// Clients send envelopes holding one or more requests as added by envelope.AddRequest,
+// the server replies with an envelope holding a response for every request as added by
+// envelope.AddResponse, see envelope.ResponseFor.
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/deitas/apo/block"
+	"github.com/deitas/apo/envelope"
+)
+
+const (
+	MethodKey = envelope.RequestMethodKey
+	ArgsKey   = envelope.RequestArgsKey
+	ResultKey = envelope.ResponseResultKey
+	ErrorKey  = envelope.ResponseErrorKey
+)
+
+// HandlerFunc handles the arguments of a request, as returned by block.Block.Interface().
+// The result is parsed into the response with envelope.ParseBlock.
+type HandlerFunc func(args interface{}) (result interface{}, err error)
+
+type Options struct {
+	// MaxFrameSize bounds received envelopes, zero disables the limit.
+	MaxFrameSize  uint32
+	DecodeOptions envelope.DecodeOptions
+}
+
+// DefaultOptions limit the envelopes received by servers and clients created without options.
+// Options given instead replace them as a whole, zero values disabling a limit.
+var DefaultOptions = Options{
+	MaxFrameSize: 16 << 20,
+	DecodeOptions: envelope.DecodeOptions{
+		MaxBlocks:         1 << 16,
+		MaxDepth:          64,
+		MaxExpandedBlocks: 1 << 20,
+	},
+}
+
+// decodeOptions bounds the size of decoded envelopes by MaxFrameSize as well.
+func (options Options) decodeOptions() envelope.DecodeOptions {
+	var decodeOptions envelope.DecodeOptions = options.DecodeOptions
+
+	if options.MaxFrameSize > 0 && (decodeOptions.MaxSize == 0 || decodeOptions.MaxSize > int64(options.MaxFrameSize)) {
+		decodeOptions.MaxSize = int64(options.MaxFrameSize)
+	}
+
+	return decodeOptions
+}
+
+// Error is returned by Client.Call when the handler of the server failed.
+type Error struct {
+	Method  string
+	Message string
+}
+
+func (rpcError *Error) Error() string {
+	return fmt.Sprintf("rpc %s: %s", rpcError.Method, rpcError.Message)
+}
+
+// lookupString returns the value of the string block under key.
+func lookupString(objectBlock *envelope.ObjectBlock, key string) (value string, err error) {
+	child, hasChild := objectBlock.Lookup(key)
+	stringBlock, isString := child.(*envelope.StringBlock)
+
+	if !hasChild || !isString {
+		err = fmt.Errorf("missing %q string", key)
+		return
+	}
+
+	value = stringBlock.String()
+	return
+}
+
+// lookupInterface returns the value of the block under key, nil when there is none.
+func lookupInterface(objectBlock *envelope.ObjectBlock, key string) (value interface{}, err error) {
+	var (
+		child    block.Block
+		hasChild bool
+	)
+
+	if child, hasChild = objectBlock.Lookup(key); !hasChild {
+		return
+	}
+
+	return child.Interface()
+}
@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/envelope"
+)
+
+func newTestClient(t *testing.T) (client *Client, conn net.Conn) {
+	t.Helper()
+
+	var (
+		server     *Server = NewServer()
+		serverConn net.Conn
+	)
+
+	server.Register("echo", func(args interface{}) (interface{}, error) {
+		return args, nil
+	})
+
+	server.Register("fail", func(interface{}) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+
+	server.Register("panic", func(interface{}) (interface{}, error) {
+		panic("handler")
+	})
+
+	server.Register("unparsable", func(interface{}) (interface{}, error) {
+		return make(chan int), nil
+	})
+
+	conn, serverConn = net.Pipe()
+	go server.ServeConn(serverConn)
+
+	client = NewClient(conn)
+	t.Cleanup(func() { client.Close() })
+
+	return
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		args     interface{}
+		expected interface{}
+		message  string
+	}{
+		{"string", "echo", "value", "value", ""},
+		{"object", "echo", map[string]interface{}{"a": []interface{}{true, "b"}}, map[string]interface{}{"a": []interface{}{true, "b"}}, ""},
+		{"no args", "echo", nil, nil, ""},
+		{"handler error", "fail", nil, nil, "failed"},
+		{"handler panic", "panic", nil, nil, "handler panicked: handler"},
+		{"unknown method", "missing", nil, nil, `unknown method "missing"`},
+	}
+
+	client, _ := newTestClient(t)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := client.Call(test.method, test.args)
+
+			if test.message != "" {
+				var rpcError *Error
+
+				if !errors.As(err, &rpcError) || rpcError.Method != test.method || rpcError.Message != test.message {
+					t.Fatalf("expected rpc error %q, got %v", test.message, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("expected %#v, got %#v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	client, conn := newTestClient(t)
+
+	// the server ignores envelopes without requests
+	if err := apo.NewFrameWriter(conn).WriteEnvelope(envelope.NewEnvelope()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Batch(); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []*Call{
+		{Method: "echo", Args: "first"},
+		{Method: "fail"},
+		{Method: "unparsable"},
+		{Method: "echo", Args: "last"},
+	}
+
+	if err := client.Batch(calls...); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls[0].Err != nil || calls[0].Result != "first" {
+		t.Fatalf("expected first, got %v, %v", calls[0].Result, calls[0].Err)
+	}
+
+	var rpcError *Error
+
+	if !errors.As(calls[1].Err, &rpcError) || rpcError.Message != "failed" {
+		t.Fatalf("expected rpc error, got %v", calls[1].Err)
+	}
+
+	if !errors.As(calls[2].Err, &rpcError) || rpcError.Method != "unparsable" {
+		t.Fatalf("expected rpc error, got %v", calls[2].Err)
+	}
+
+	if calls[3].Err != nil || calls[3].Result != "last" {
+		t.Fatalf("expected last, got %v, %v", calls[3].Result, calls[3].Err)
+	}
+}
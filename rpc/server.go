@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/deitas/apo"
+	"github.com/deitas/apo/envelope"
+)
+
+type Server struct {
+	handlers map[string]HandlerFunc
+	mutex    sync.RWMutex
+	options  Options
+}
+
+func NewServer(options ...Options) (server *Server) {
+	server = &Server{
+		handlers: map[string]HandlerFunc{},
+		options:  DefaultOptions,
+	}
+
+	if len(options) > 0 {
+		server.options = options[0]
+	}
+
+	return
+}
+
+// Register sets the handler of method, replacing a previously registered one.
+func (server *Server) Register(method string, handler HandlerFunc) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.handlers[method] = handler
+}
+
+// Serve accepts connections of listener and serves each one in its own goroutine.
+func (server *Server) Serve(listener net.Listener) (err error) {
+	var conn net.Conn
+
+	for {
+		if conn, err = listener.Accept(); err != nil {
+			return
+		}
+
+		go server.ServeConn(conn)
+	}
+}
+
+// ServeConn replies to requests read from conn until it is closed by the client, then closes it.
+func (server *Server) ServeConn(conn net.Conn) (err error) {
+	var (
+		frameReader *apo.FrameReader = apo.NewFrameReader(conn, server.options.MaxFrameSize, server.options.decodeOptions())
+		frameWriter *apo.FrameWriter = apo.NewFrameWriter(conn)
+		request     *envelope.Envelope
+		response    *envelope.Envelope
+	)
+
+	defer conn.Close()
+
+	for {
+		if request, err = frameReader.ReadEnvelope(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return
+		}
+
+		if response, err = server.handle(request); err != nil {
+			return
+		} else if response == nil {
+			continue
+		}
+
+		if err = frameWriter.WriteEnvelope(response); err != nil {
+			return
+		}
+	}
+}
+
+// handle replies to every request of the envelope, failures of a single request are sent in its response.
+// Envelopes without requests are ignored, response being nil.
+func (server *Server) handle(request *envelope.Envelope) (response *envelope.Envelope, err error) {
+	var requests []*envelope.ObjectBlock = request.Requests()
+
+	if len(requests) == 0 {
+		return
+	}
+
+	response = envelope.NewEnvelope()
+
+	for _, requestBlock := range requests {
+		var (
+			method     string
+			args       interface{}
+			result     interface{}
+			handlerErr error
+		)
+
+		if method, handlerErr = lookupString(requestBlock, MethodKey); handlerErr == nil {
+			if args, handlerErr = lookupInterface(requestBlock, ArgsKey); handlerErr == nil {
+				result, handlerErr = server.call(method, args)
+			}
+		}
+
+		if _, err = response.AddResponse(requestBlock, result, handlerErr); err != nil {
+			// the result cannot be parsed, so the failure is sent instead
+			if _, err = response.AddResponse(requestBlock, nil, err); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func (server *Server) call(method string, args interface{}) (result interface{}, err error) {
+	server.mutex.RLock()
+	handler, hasHandler := server.handlers[method]
+	server.mutex.RUnlock()
+
+	if !hasHandler {
+		err = fmt.Errorf("unknown method %q", method)
+		return
+	}
+
+	// a panicking handler fails its own request instead of the whole server
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = nil
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+
+	return handler(args)
+}